@@ -19,11 +19,13 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 type HttpApi struct {
@@ -61,15 +63,54 @@ func (a *HttpApi) bootRouter() *mux.Router {
 	router.HandleFunc("/v1/queues", a.CreateQueue).Methods(http.MethodPost)
 	router.HandleFunc("/v1/queues", a.RetrieveQueues).Methods(http.MethodGet)
 	router.HandleFunc("/v1/queues/{qid}", a.RetrieveQueue).Methods(http.MethodGet)
+	router.HandleFunc("/v1/queues/{qid}", a.UpdateQueue).Methods(http.MethodPatch)
+	router.HandleFunc("/v1/queues/{qid}", a.DeleteQueue).Methods(http.MethodDelete)
 
 	router.HandleFunc("/v1/queues/{qid}/jobs", a.CreateJob).Methods(http.MethodPost)
 	router.HandleFunc("/v1/queues/{qid}/jobs", a.RetrieveJobsByQueue).Methods(http.MethodGet)
 	router.HandleFunc("/v1/queues/{qid}/jobs/{jid}", a.RetrieveJobByQueue).Methods(http.MethodGet)
+	router.HandleFunc("/v1/queues/{qid}/jobs/{jid}", a.UpdateJob).Methods(http.MethodPatch)
+	router.HandleFunc("/v1/queues/{qid}/jobs/{jid}", a.CancelJob).Methods(http.MethodDelete)
+	router.HandleFunc("/v1/queues/{qid}/jobs/{jid}/events", a.StreamJobEvents).Methods(http.MethodGet)
+	router.HandleFunc("/v1/queues/{qid}/jobs/{jid}/tasks/{tid}/commands/{cid}/logs", a.RetrieveCommandLogs).Methods(http.MethodGet)
+
+	router.HandleFunc("/v1/queues/{qid}/schedules", a.CreateSchedule).Methods(http.MethodPost)
+	router.HandleFunc("/v1/queues/{qid}/schedules", a.RetrieveSchedules).Methods(http.MethodGet)
+	router.HandleFunc("/v1/queues/{qid}/schedules/{sid}", a.RetrieveSchedule).Methods(http.MethodGet)
+	router.HandleFunc("/v1/queues/{qid}/schedules/{sid}", a.UpdateSchedule).Methods(http.MethodPatch)
+	router.HandleFunc("/v1/queues/{qid}/schedules/{sid}", a.DeleteSchedule).Methods(http.MethodDelete)
 
 	router.HandleFunc("/v1/queues/{qid}/nodes", a.AddNode).Methods(http.MethodPost)
 	router.HandleFunc("/v1/queues/{qid}/nodes", a.RetrieveNodes).Methods(http.MethodGet)
 	router.HandleFunc("/v1/queues/{qid}/nodes/{nid}", a.RetrieveNode).Methods(http.MethodGet)
+	router.HandleFunc("/v1/queues/{qid}/nodes/{nid}", a.DeleteNode).Methods(http.MethodDelete)
 	router.HandleFunc("/swagger.json", a.Swagger).Methods(http.MethodGet)
+	router.Use(resolveQueue(a.storage.RetrieveQueueIDByName))
 
 	return router
 }
+
+// resolveQueue lets the routes refer to a queue by its name as well as by its ID, handing
+// the handlers its ID. References made only of digits are read as IDs.
+func resolveQueue(byName func(name string) (uint, error)) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			if ref, ok := vars["qid"]; ok {
+				if _, err := strconv.ParseUint(ref, 10, 64); err != nil {
+					queueID, err := byName(ref)
+					if err != nil {
+						Write(w, http.StatusNotFound, ErrorResponse{
+							Message: fmt.Sprintf("Queue [%s] not found", ref),
+							Status:  http.StatusNotFound,
+						})
+						return
+					}
+					vars["qid"] = strconv.FormatUint(uint64(queueID), 10)
+					r = mux.SetURLVars(r, vars)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
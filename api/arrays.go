@@ -0,0 +1,159 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxArrayTasks bounds how many tasks a job may have once its task arrays are expanded
+const MaxArrayTasks = 100000
+
+// IndexParameter is the parameter holding the index of each task expanded from an array
+const IndexParameter = "index"
+
+var parameterName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var (
+	ArrayRangeOrMatrixErr = errors.New("a task array takes either a Range or a Matrix")
+	MaxArrayTasksErr      = fmt.Errorf("a job may not have more than %d tasks", MaxArrayTasks)
+)
+
+// expandArrays replaces each task spec declaring an array with the tasks of the array, whose
+// IDs are the one of the array followed by their index, e.g. "render[3]". A task depending on
+// an array depends on each of its tasks.
+func expandArrays(spec *JobSpec) error {
+	var tasks []TaskSpec
+	elements := make(map[string][]string)
+	for i, taskSpec := range spec.Tasks {
+		taskSpec.source = i
+		if taskSpec.Array == nil {
+			tasks = append(tasks, taskSpec)
+			continue
+		}
+		params, err := taskSpec.Array.parameters(MaxArrayTasks - len(tasks))
+		if err != nil {
+			return fmt.Errorf("Task [%s]: %s", taskSpec.ID, err.Error())
+		}
+		for _, p := range params {
+			task := instantiate(taskSpec, p.values)
+			index := p.index
+			task.index = &index
+			if taskSpec.ID != "" {
+				task.ID = fmt.Sprintf("%s[%d]", taskSpec.ID, index)
+				elements[taskSpec.ID] = append(elements[taskSpec.ID], task.ID)
+			}
+			tasks = append(tasks, task)
+		}
+	}
+
+	if len(elements) > 0 {
+		for i := range tasks {
+			var dependsOn []string
+			for _, dep := range tasks[i].DependsOn {
+				if ids, ok := elements[dep]; ok {
+					dependsOn = append(dependsOn, ids...)
+				} else {
+					dependsOn = append(dependsOn, dep)
+				}
+			}
+			tasks[i].DependsOn = dependsOn
+		}
+	}
+	spec.Tasks = tasks
+	return nil
+}
+
+// arrayParameters are the values of the parameters of one task of an array
+type arrayParameters struct {
+	index  int
+	values map[string]string
+}
+
+// parameters lists the parameters of each task of the array, failing when there are more than max
+func (a *ArraySpec) parameters(max int) ([]arrayParameters, error) {
+	if (a.Range == nil) == (a.Matrix == nil) {
+		return nil, ArrayRangeOrMatrixErr
+	}
+	if a.Range != nil {
+		if len(a.Range) != 2 || a.Range[0] > a.Range[1] {
+			return nil, errors.New("the Range of a task array is its first and its last index, e.g. [1, 100]")
+		}
+		// a negative difference overflowed
+		span := a.Range[1] - a.Range[0]
+		if span >= max || span < 0 {
+			return nil, MaxArrayTasksErr
+		}
+		// counting by offset, since the last index may be the largest int
+		params := make([]arrayParameters, 0, span+1)
+		for offset := 0; offset <= span; offset++ {
+			i := a.Range[0] + offset
+			params = append(params, arrayParameters{index: i, values: map[string]string{IndexParameter: fmt.Sprint(i)}})
+		}
+		return params, nil
+	}
+
+	names := make([]string, 0, len(a.Matrix))
+	count := 1
+	for name, values := range a.Matrix {
+		if !parameterName.MatchString(name) || name == IndexParameter {
+			return nil, fmt.Errorf("[%s] may not name a parameter", name)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("the parameter [%s] has no values", name)
+		}
+		if count *= len(values); count > max {
+			return nil, MaxArrayTasksErr
+		}
+		names = append(names, name)
+	}
+	// the combinations are listed in the order of the names, the last one changing the fastest
+	sort.Strings(names)
+
+	params := make([]arrayParameters, count)
+	for i := range params {
+		values := map[string]string{IndexParameter: fmt.Sprint(i)}
+		rest := i
+		for j := len(names) - 1; j >= 0; j-- {
+			options := a.Matrix[names[j]]
+			values[names[j]] = options[rest%len(options)]
+			rest /= len(options)
+		}
+		params[i] = arrayParameters{index: i, values: values}
+	}
+	return params, nil
+}
+
+// instantiate is the task spec with the placeholders of the parameters, e.g. ${index},
+// replaced by their values. Placeholders of other names are left alone, since the shell
+// may expand them when the commands run.
+func instantiate(spec TaskSpec, values map[string]string) TaskSpec {
+	pairs := make([]string, 0, 2*len(values))
+	for name, value := range values {
+		pairs = append(pairs, "${"+name+"}", value)
+	}
+	r := strings.NewReplacer(pairs...)
+
+	task := TaskSpec{ID: spec.ID, source: spec.source}
+	for _, cmd := range spec.Commands {
+		task.Commands = append(task.Commands, r.Replace(cmd))
+	}
+	for _, dep := range spec.DependsOn {
+		task.DependsOn = append(task.DependsOn, r.Replace(dep))
+	}
+	if spec.Config != nil {
+		task.Config = make(map[string]string, len(spec.Config))
+		for key, value := range spec.Config {
+			task.Config[key] = r.Replace(value)
+		}
+	}
+	if spec.Metadata != nil {
+		task.Metadata = make(map[string]string, len(spec.Metadata))
+		for key, value := range spec.Metadata {
+			task.Metadata[key] = r.Replace(value)
+		}
+	}
+	return task
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestExpandRange(t *testing.T) {
+	spec := JobSpec{Tasks: []TaskSpec{
+		{
+			ID:       "render",
+			Commands: []string{"render frame-${index}.png", "echo $HOME ${HOME}"},
+			Config:   map[string]string{"docker_env.FRAME": "${index}"},
+			Array:    &ArraySpec{Range: []int{1, 3}},
+		},
+		{ID: "encode", Commands: []string{"encode"}, DependsOn: []string{"render"}},
+	}}
+	if err := expandArrays(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Tasks) != 4 {
+		t.Fatalf("expanded to %d tasks, want 4", len(spec.Tasks))
+	}
+	second := spec.Tasks[1]
+	if second.ID != "render[2]" || *second.index != 2 {
+		t.Errorf("second task is [%s] with index %d", second.ID, *second.index)
+	}
+	if want := []string{"render frame-2.png", "echo $HOME ${HOME}"}; !reflect.DeepEqual(second.Commands, want) {
+		t.Errorf("commands = %q, want %q", second.Commands, want)
+	}
+	if second.Config["docker_env.FRAME"] != "2" {
+		t.Errorf("config = %v, want the index replaced", second.Config)
+	}
+	if want := []string{"render[1]", "render[2]", "render[3]"}; !reflect.DeepEqual(spec.Tasks[3].DependsOn, want) {
+		t.Errorf("dependencies = %q, want %q", spec.Tasks[3].DependsOn, want)
+	}
+}
+
+func TestExpandRangeAtIntLimits(t *testing.T) {
+	for _, r := range [][]int{{math.MaxInt64 - 1, math.MaxInt64}, {math.MinInt64, math.MinInt64 + 1}} {
+		params, err := (&ArraySpec{Range: r}).parameters(MaxArrayTasks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(params) != 2 || params[0].index != r[0] || params[1].index != r[1] {
+			t.Errorf("range %v expanded to %+v", r, params)
+		}
+	}
+}
+
+func TestExpandMatrix(t *testing.T) {
+	spec := JobSpec{Tasks: []TaskSpec{{
+		Commands: []string{"train --lr ${lr} --model ${model} --run ${index}"},
+		Metadata: map[string]string{"model": "${model}"},
+		Array:    &ArraySpec{Matrix: map[string][]string{"model": {"small", "large"}, "lr": {"0.1", "0.01"}}},
+	}}}
+	if err := expandArrays(&spec); err != nil {
+		t.Fatal(err)
+	}
+	var commands []string
+	for _, task := range spec.Tasks {
+		commands = append(commands, task.Commands...)
+	}
+	want := []string{
+		"train --lr 0.1 --model small --run 0",
+		"train --lr 0.1 --model large --run 1",
+		"train --lr 0.01 --model small --run 2",
+		"train --lr 0.01 --model large --run 3",
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+	if spec.Tasks[3].Metadata["model"] != "large" {
+		t.Errorf("metadata = %v, want the parameter replaced", spec.Tasks[3].Metadata)
+	}
+}
+
+func TestExpandRejects(t *testing.T) {
+	cases := map[string]*ArraySpec{
+		"neither":         {},
+		"both":            {Range: []int{0, 1}, Matrix: map[string][]string{"a": {"x"}}},
+		"reversed range":  {Range: []int{3, 1}},
+		"too many":        {Range: []int{1, MaxArrayTasks + 1}},
+		"no values":       {Matrix: map[string][]string{"a": {}}},
+		"index parameter": {Matrix: map[string][]string{IndexParameter: {"x"}}},
+		"malformed name":  {Matrix: map[string][]string{"a-b": {"x"}}},
+	}
+	for name, array := range cases {
+		spec := JobSpec{Tasks: []TaskSpec{{Array: array}}}
+		if err := expandArrays(&spec); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
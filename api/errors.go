@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"net/http"
+	"strconv"
+)
+
+var MalformedBodyErr = errors.BadRequest.New("Maybe the body has a wrong shape")
+
+// statuses are the HTTP statuses of the types of the errors, the errors of other types being internal
+var statuses = map[errors.ErrorType]int{
+	errors.BadRequest:    http.StatusBadRequest,
+	errors.NotFound:      http.StatusNotFound,
+	errors.Conflict:      http.StatusConflict,
+	errors.Unprocessable: http.StatusUnprocessableEntity,
+}
+
+// storageErrors are the types of the errors of the storage, which are plain errors
+var storageErrors = map[error]errors.ErrorType{
+	storage.QueueNameTakenErr: errors.Conflict,
+}
+
+// writeError writes the error with the status of its type, listing the fields it is about
+func writeError(w http.ResponseWriter, err error) {
+	errorType, known := storageErrors[err]
+	if !known {
+		errorType = errors.GetType(err)
+	}
+	status, ok := statuses[errorType]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	response := ErrorResponse{
+		Message: err.Error(),
+		Status:  uint(status),
+	}
+	for _, context := range errors.GetErrorContexts(err) {
+		response.Errors = append(response.Errors, FieldError{Field: context["field"], Message: context["message"]})
+	}
+	Write(w, status, response)
+}
+
+// routeQueueID reads the ID of the queue of the route
+func routeQueueID(r *http.Request) (uint, error) {
+	ref := mux.Vars(r)["qid"]
+	queueID, err := strconv.ParseUint(ref, 10, 64)
+	if err != nil {
+		return 0, errors.BadRequest.Newf("Malformed queue ID [%s]", ref)
+	}
+	return uint(queueID), nil
+}
+
+func queueNotFound(queueID int) error {
+	return errors.NotFound.New(fmt.Sprintf("Queue with ID %d not found", queueID))
+}
+
+// notFound is the error of a resource the request refers to that does not exist
+func notFound(format string, args ...interface{}) error {
+	return errors.NotFound.Newf(format, args...)
+}
+
+// badRequest gives the error of a malformed field of the request its type
+func badRequest(err error) error {
+	return errors.BadRequest.New(err.Error())
+}
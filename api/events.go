@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeepAlivePeriod is how often a comment is sent on an idle stream so proxies keep it open
+const KeepAlivePeriod = 15 * time.Second
+
+func (a *HttpApi) StreamJobEvents(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation GET /v1/queues/{queue_id}/jobs/{job_id}/events streamJobEvents
+	//
+	// Stream the state transitions of the job, its tasks and commands, and the output
+	// of the commands as Server-Sent Events. The stream ends once the job completes.
+	// ---
+	// produces:
+	// - text/event-stream
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The job id
+	//   required: true
+	//   type: string
+	// - name: Last-Event-ID
+	//   in: header
+	//   description: Resume the stream after this event
+	//   type: integer
+	// responses:
+	//   '200':
+	//     description: The stream of events
+	//   '404':
+	//     description: The job was not found
+	params := mux.Vars(r)
+
+	queueID, _ := strconv.Atoi(params["qid"])
+	jobID, _ := strconv.Atoi(params["jid"])
+
+	job, err := a.storage.RetrieveJobByQueue(uint(jobID), uint(queueID))
+	if err != nil {
+		Write(w, http.StatusNotFound, ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusNotFound,
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		Write(w, http.StatusInternalServerError, ErrorResponse{
+			Message: "Streaming is not supported",
+			Status:  http.StatusInternalServerError,
+		})
+		return
+	}
+
+	// browsers resume through the header, other clients may prefer the query
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	last, _ := strconv.ParseUint(lastID, 10, 64)
+
+	backlog, sub := events.DefaultBus.Subscribe(job.ID, last)
+	defer events.DefaultBus.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range backlog {
+		if writeEvent(w, e) {
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+	if job.State.IsCompleted() {
+		return
+	}
+
+	keepAlive := time.NewTicker(KeepAlivePeriod)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// the client fell behind, it reconnects and resumes from the last event it got
+				return
+			}
+			last := writeEvent(w, e)
+			flusher.Flush()
+			if last {
+				return
+			}
+		case <-keepAlive.C:
+			_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes the event on the stream, telling if it was the last of the job
+func writeEvent(w http.ResponseWriter, e events.Event) bool {
+	data, _ := json.Marshal(e)
+	_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Kind, data)
+
+	if e.Kind != events.JobKind {
+		return false
+	}
+	return e.State == storage.JobFinished.String() || e.State == storage.JobFailed.String() ||
+		e.State == storage.JobCancelled.String()
+}
@@ -5,12 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +27,8 @@ type Version struct {
 type QueueResponse struct {
 	ID           uint   `json:"ID"`
 	Name         string `json:"Name"`
+	Policy       string `json:"Policy"`
+	State        string `json:"State"`
 	PendingTasks uint   `json:"PendingTasks"`
 	RunningTasks uint   `json:"RunningTasks"`
 	Nodes        uint   `json:"Nodes"`
@@ -32,30 +36,43 @@ type QueueResponse struct {
 }
 
 type JobResponse struct {
-	ID        uint            `json:"ID"`
-	Label     string          `json:"Label"`
-	State     string          `json:"State"`
-	CreatedAt time.Time       `json:"CreatedAt"`
-	UpdatedAt time.Time       `json:"UpdatedAt"`
-	Tasks     []*TaskResponse `json:"Tasks"`
+	ID                uint            `json:"ID"`
+	Label             string          `json:"Label"`
+	State             string          `json:"State"`
+	Priority          int             `json:"Priority"`
+	CreatedAt         time.Time       `json:"CreatedAt"`
+	UpdatedAt         time.Time       `json:"UpdatedAt"`
+	OnUpstreamFailure string          `json:"OnUpstreamFailure"`
+	Tasks             []*TaskResponse `json:"Tasks"`
 }
 
 type TaskResponse struct {
-	ID       uint               `json:"ID"`
-	State    string             `json:"State"`
-	Commands []*CommandResponse `json:"Commands"`
+	ID        uint                   `json:"ID"`
+	SpecID    string                 `json:"SpecID,omitempty"`
+	State     string                 `json:"State"`
+	Reason    string                 `json:"Reason,omitempty"`
+	DependsOn []string               `json:"DependsOn,omitempty"`
+	Commands  []*CommandResponse     `json:"Commands"`
+	Attempts  []*storage.TaskAttempt `json:"Attempts"`
 }
 
 type CommandResponse struct {
 	ID         uint   `json:"ID"`
 	State      string `json:"State"`
 	RawCommand string `json:"RawCommand"`
-	ExitCode   int8   `json:"ExitCode"`
+	ExitCode   int    `json:"ExitCode"`
 }
 
 type ErrorResponse struct {
+	Message string       `json:"Message"`
+	Status  uint         `json:"Status"`
+	Errors  []FieldError `json:"Errors,omitempty"`
+}
+
+// FieldError tells what is wrong with a field of the request, e.g. Tasks[0].Commands
+type FieldError struct {
+	Field   string `json:"Field"`
 	Message string `json:"Message"`
-	Status  uint   `json:"Status"`
 }
 
 // swagger:model jobSpec
@@ -63,16 +80,42 @@ type JobSpec struct {
 	// label
 	// required: true
 	Label string `json:"Label"`
+	// priority of the tasks against the ones of other jobs, higher runs first
+	// required: false
+	Priority int `json:"Priority"`
+	// config every task takes unless it sets the key itself, e.g. its retry policy
+	// required: false
+	Config map[string]string `json:"Config"`
+	// what happens to the tasks depending on one that failed: skip (the default), fail or continue
+	// required: false
+	OnUpstreamFailure string `json:"OnUpstreamFailure"`
 	// tasks
 	// required: true
 	Tasks []TaskSpec `json:"Tasks"`
 }
 
 type TaskSpec struct {
-	ID       string            `json:"ID"`
-	Config   map[string]string `json:"Config"`
-	Commands []string          `json:"Commands"`
-	Metadata map[string]string `json:"Metadata"`
+	ID        string            `json:"ID"`
+	Config    map[string]string `json:"Config"`
+	Commands  []string          `json:"Commands"`
+	Metadata  map[string]string `json:"Metadata"`
+	DependsOn []string          `json:"DependsOn"`
+	Array     *ArraySpec        `json:"Array"`
+	// index is the one of the task among the ones expanded from its array, and source the
+	// position in the job spec of the task spec it was expanded from
+	index  *int
+	source int
+}
+
+// ArraySpec expands a task spec into one task per index of its Range or per combination of
+// the values of the parameters of its Matrix. The placeholders of the parameters, e.g.
+// ${sample}, and of the index, ${index}, are replaced in the commands, config and metadata.
+type ArraySpec struct {
+	// Range is the first and the last index, e.g. [1, 100]
+	Range []int `json:"Range"`
+	// Matrix maps each parameter to its values, e.g. {"sample": ["a", "b"]}. The tasks are
+	// indexed from zero.
+	Matrix map[string][]string `json:"Matrix"`
 }
 
 // swagger:model GenericIdResponse
@@ -87,10 +130,34 @@ var (
 	EncodeResErr = errors.New("error while trying encode response")
 )
 
+// swagger:model QueueSpec
+type QueueSpec struct {
+	// name the queue can be referred by in place of its ID, unique among the queues
+	// required: false
+	Name string `json:"Name"`
+	// scheduling policy, priority by default
+	// required: false
+	Policy string `json:"Policy"`
+}
+
+// queueNamePattern is the shape of queue names, which appear on the routes
+var queueNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// checkQueueName tells if the name may be given to a queue
+func checkQueueName(name string) error {
+	if !queueNamePattern.MatchString(name) {
+		return errors.New("The queue name must have up to 64 letters, digits, dots, dashes or underscores, starting with a letter or a digit")
+	}
+	if _, err := strconv.ParseUint(name, 10, 64); err == nil {
+		return errors.New("The queue name can not be made only of digits, which are read as an ID")
+	}
+	return nil
+}
+
 func (a *HttpApi) CreateQueue(w http.ResponseWriter, r *http.Request) {
 	// swagger:operation POST /v1/queues/ createQueue
 	//
-	// Creates a queue
+	// Creates a queue, whose ID is assigned by the server
 	// ---
 	// consumes:
 	// - application/json
@@ -102,45 +169,50 @@ func (a *HttpApi) CreateQueue(w http.ResponseWriter, r *http.Request) {
 	//   description: The queue payload
 	//   required: true
 	//   schema:
-	//       "$ref": "#/definitions/Queue"
+	//       "$ref": "#/definitions/QueueSpec"
 	// responses:
 	//   '201':
 	//     description: The queue ID
 	//     schema:
 	//       "$ref": "#/definitions/GenericIdResponse"
-	var queue storage.Queue
-	err := json.NewDecoder(r.Body).Decode(&queue)
-
-	if err != nil {
-		Write(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Maybe the body has a wrong shape",
-			Status:  http.StatusBadRequest,
-		})
+	//   '400':
+	//     description: The name is malformed or the policy is unknown
+	//   '409':
+	//     description: The name is taken by another queue
+	var spec QueueSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, MalformedBodyErr)
+		return
 	}
 
-	if queue.ID == 0 {
-		Write(w, http.StatusBadRequest, ErrorResponse{
-			Message: "The queue ID can not be 0",
-			Status:  http.StatusBadRequest,
-		})
+	if spec.Name != "" {
+		if err := checkQueueName(spec.Name); err != nil {
+			writeError(w, badRequest(err))
+			return
+		}
+	}
+	if spec.Policy == "" {
+		spec.Policy = service.DefaultPolicy
+	} else if !service.ValidPolicy(spec.Policy) {
+		writeError(w, service.UnknownPolicyErr)
+		return
 	}
 
-	err = a.storage.SaveQueue(&queue)
-
-	if err != nil {
-		Write(w, http.StatusInternalServerError, ErrorResponse{
-			Message: "Error while trying to save the new queue",
-			Status:  http.StatusInternalServerError,
-		})
-	} else {
-		super := a.arrebol.HireSupervisor(&queue)
+	queue := &storage.Queue{Name: spec.Name, Policy: spec.Policy}
+	if err := a.storage.CreateQueue(queue); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := service.SeedNode(queue.ID); err != nil {
+		log.Printf("Unable to register the default node of queue [%d]: %s", queue.ID, err.Error())
+	}
 
-		go super.Start()
+	super := a.arrebol.HireSupervisor(queue)
+	go super.Start()
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		_, _ = fmt.Fprintf(w, `{"ID": "%d"}`, queue.ID)
-	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"ID": "%d"}`, queue.ID)
 }
 
 func (a *HttpApi) RetrieveQueue(w http.ResponseWriter, r *http.Request) {
@@ -192,6 +264,137 @@ func (a *HttpApi) RetrieveQueue(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// swagger:model QueuePatch
+type QueuePatch struct {
+	Name   *string `json:"Name"`
+	Policy string  `json:"Policy"`
+	State  string  `json:"State"`
+}
+
+func (a *HttpApi) UpdateQueue(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation PATCH /v1/queues/{queue_id} updateQueue
+	//
+	// Rename a queue, change its scheduling policy, or pause, drain or reopen it. A paused queue
+	// accepts jobs but runs none of their tasks, and a draining one accepts no new jobs.
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: body
+	//   in: body
+	//   description: The fields to change
+	//   required: true
+	//   schema:
+	//       "$ref": "#/definitions/QueuePatch"
+	// responses:
+	//   '200':
+	//     description: The updated queue
+	//     schema:
+	//       "$ref": "#/definitions/QueueResponse"
+	//   '400':
+	//     description: The name is malformed, or the policy or the state is unknown
+	//   '409':
+	//     description: The name is taken by another queue
+	queueID, err := routeQueueID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var patch QueuePatch
+	if err = json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, MalformedBodyErr)
+		return
+	}
+
+	if _, err = a.storage.RetrieveQueue(queueID); err != nil {
+		writeError(w, queueNotFound(int(queueID)))
+		return
+	}
+
+	var update storage.QueueUpdate
+	if patch.Name != nil {
+		if err = checkQueueName(*patch.Name); err != nil {
+			writeError(w, badRequest(err))
+			return
+		}
+		update.Name = patch.Name
+	}
+	if patch.Policy != "" {
+		if !service.ValidPolicy(patch.Policy) {
+			writeError(w, service.UnknownPolicyErr)
+			return
+		}
+		update.Policy = &patch.Policy
+	}
+	if patch.State != "" {
+		state, err := service.ParseQueueState(patch.State)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		update.State = &state
+	}
+
+	if err = a.arrebol.UpdateQueue(queueID, update); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	queue, _ := a.storage.RetrieveQueue(queueID)
+	pendingTasks := a.storage.RetrieveTasksByState(queue.ID, storage.TaskPending)
+	runningTasks := a.storage.RetrieveTasksByState(queue.ID, storage.TaskRunning)
+	Write(w, http.StatusOK, responseFromQueue(queue, uint(len(pendingTasks)), uint(len(runningTasks)), uint(len(queue.Workers))))
+}
+
+func (a *HttpApi) DeleteQueue(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation DELETE /v1/queues/{queue_id} deleteQueue
+	//
+	// Delete a queue along with its schedules and nodes, firing its supervisor
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: force
+	//   in: query
+	//   description: Cancel the jobs of the queue not completed instead of refusing the deletion
+	//   required: false
+	//   type: boolean
+	// responses:
+	//   '204':
+	//     description: The queue was deleted
+	//   '404':
+	//     description: The queue does not exist
+	//   '409':
+	//     description: The queue is the default one, or it has jobs not completed and the deletion was not forced
+	queueID, _ := strconv.Atoi(mux.Vars(r)["qid"])
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+	queue, err := a.storage.RetrieveQueue(uint(queueID))
+	if err != nil {
+		writeError(w, queueNotFound(queueID))
+		return
+	}
+
+	if err = a.arrebol.DeleteQueue(queue, force); err != nil {
+		writeError(w, err)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func (a *HttpApi) RetrieveQueues(w http.ResponseWriter, r *http.Request) {
 	// swagger:operation GET /v1/queues/ getQueues
 	//
@@ -223,6 +426,7 @@ func (a *HttpApi) RetrieveQueues(w http.ResponseWriter, r *http.Request) {
 			pendingTasks := a.storage.RetrieveTasksByState(queue.ID, storage.TaskPending)
 			runningTasks := a.storage.RetrieveTasksByState(queue.ID, storage.TaskRunning)
 			workers, _ := a.storage.RetrieveWorkersByQueueID(queue.ID)
+			queue.Nodes, _ = a.storage.RetrieveNodesByQueueID(queue.ID)
 			curQueue := responseFromQueue(queue, uint(len(pendingTasks)), uint(len(runningTasks)), uint(len(workers)))
 			response = append(response, curQueue)
 		}
@@ -256,43 +460,56 @@ func (a *HttpApi) CreateJob(w http.ResponseWriter, r *http.Request) {
 	//     description: The job id
 	//     schema:
 	//       "$ref": "#/definitions/GenericIdResponse"
-	var jobSpec JobSpec
-	params := mux.Vars(r)
-
-	queueIDStr := params["qid"]
-
-	err := json.NewDecoder(r.Body).Decode(&jobSpec)
-
+	//   '400':
+	//     description: The body is not a job spec
+	//   '404':
+	//     description: The queue does not exist
+	//   '409':
+	//     description: The queue is draining
+	//   '422':
+	//     description: The job spec is invalid, the fields found invalid are listed
+	queueID, err := routeQueueID(r)
 	if err != nil {
-		log.Println(ProcReqErr)
+		writeError(w, err)
+		return
+	}
+	queue, err := a.storage.RetrieveQueue(queueID)
+	if err != nil {
+		writeError(w, queueNotFound(int(queueID)))
+		return
 	}
 
-	job := extractFromSpec(jobSpec)
-
-	queueID, _ := strconv.Atoi(queueIDStr)
-	queue, err := a.storage.RetrieveQueue(uint(queueID))
-
+	var jobSpec JobSpec
+	if err = json.NewDecoder(r.Body).Decode(&jobSpec); err != nil {
+		writeError(w, MalformedBodyErr)
+		return
+	}
+	job, err := newJob(&jobSpec)
 	if err != nil {
-		Write(w, http.StatusInternalServerError, ErrorResponse{
-			Message: err.Error(),
-			Status:  http.StatusInternalServerError,
-		})
-	} else {
-		queue.Jobs = append(queue.Jobs, job)
-		err = a.storage.SaveQueue(queue)
-		if err != nil {
-			Write(w, http.StatusInternalServerError, ErrorResponse{
-				Message: err.Error(),
-				Status:  http.StatusInternalServerError,
-			})
-		}
+		writeError(w, err)
+		return
+	}
+	if err = a.submitJob(queue, job); err != nil {
+		writeError(w, err)
+		return
+	}
 
-		a.arrebol.AcceptJob(job)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"ID": "%d"}`, job.ID)
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		_, _ = fmt.Fprintf(w, `{"ID": "%d"}`, job.ID)
+// submitJob persists the job on the queue and hands it to the dispatcher
+func (a *HttpApi) submitJob(queue *storage.Queue, job *storage.Job) error {
+	if queue.State == storage.QueueDraining {
+		return service.QueueDrainingErr
+	}
+	queue.Jobs = append(queue.Jobs, job)
+	if err := a.storage.SaveQueue(queue); err != nil {
+		return err
 	}
+	a.arrebol.AcceptJob(job)
+	return nil
 }
 
 func (a *HttpApi) RetrieveJobsByQueue(w http.ResponseWriter, r *http.Request) {
@@ -378,16 +595,119 @@ func (a *HttpApi) RetrieveJobByQueue(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (a *HttpApi) AddNode(w http.ResponseWriter, r *http.Request) {
-	Write(w, http.StatusAccepted, `{"Message": "no support yet"}`)
+func (a *HttpApi) CancelJob(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation DELETE /v1/queues/{queue_id}/jobs/{job_id} cancelJob
+	//
+	// Cancel a job, aborting its running tasks
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The job id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: The cancelled job
+	//     schema:
+	//        "$ref": "#/definitions/Job"
+	//   '409':
+	//     description: The job has already completed
+	params := mux.Vars(r)
+
+	queueID, _ := strconv.Atoi(params["qid"])
+	jobID, _ := strconv.Atoi(params["jid"])
+
+	job, err := a.storage.RetrieveJobByQueue(uint(jobID), uint(queueID))
+
+	if err != nil {
+		Write(w, http.StatusNotFound, ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusNotFound,
+		})
+		return
+	}
+
+	if err = a.arrebol.CancelJob(job); err != nil {
+		writeError(w, err)
+	} else {
+		job, _ = a.storage.RetrieveJobByQueue(job.ID, job.QueueID)
+		Write(w, http.StatusOK, newJobResponse(job))
+	}
 }
 
-func (a *HttpApi) RetrieveNode(w http.ResponseWriter, r *http.Request) {
-	Write(w, http.StatusAccepted, `{"Message": "no support yet"}`)
+// swagger:model JobPatch
+type JobPatch struct {
+	Priority *int `json:"Priority"`
 }
 
-func (a *HttpApi) RetrieveNodes(w http.ResponseWriter, r *http.Request) {
-	Write(w, http.StatusAccepted, `{"Message": "no support yet"}`)
+func (a *HttpApi) UpdateJob(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation PATCH /v1/queues/{queue_id}/jobs/{job_id} updateJob
+	//
+	// Change the priority of a job, reordering its tasks still waiting for a worker
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The job id
+	//   required: true
+	//   type: string
+	// - name: body
+	//   in: body
+	//   description: The fields to change
+	//   required: true
+	//   schema:
+	//       "$ref": "#/definitions/JobPatch"
+	// responses:
+	//   '200':
+	//     description: The updated job
+	//     schema:
+	//        "$ref": "#/definitions/Job"
+	//   '409':
+	//     description: The job has already completed
+	params := mux.Vars(r)
+
+	queueID, _ := strconv.Atoi(params["qid"])
+	jobID, _ := strconv.Atoi(params["jid"])
+
+	var patch JobPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, MalformedBodyErr)
+		return
+	}
+
+	job, err := a.storage.RetrieveJobByQueue(uint(jobID), uint(queueID))
+
+	if err != nil {
+		writeError(w, notFound("%s", err.Error()))
+		return
+	}
+
+	if patch.Priority != nil {
+		if err = a.arrebol.SetJobPriority(job, *patch.Priority); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	job, _ = a.storage.RetrieveJobByQueue(job.ID, job.QueueID)
+	Write(w, http.StatusOK, newJobResponse(job))
 }
 
 func (a *HttpApi) GetVersion(w http.ResponseWriter, r *http.Request) {
@@ -454,12 +774,14 @@ func newJobResponses(jobs []*storage.Job) []JobResponse {
 func newJobResponse(job *storage.Job) *JobResponse {
 	tsr := newTasksResponse(job.Tasks)
 	return &JobResponse{
-		ID:        job.ID,
-		Label:     job.Label,
-		State:     job.State.String(),
-		CreatedAt: job.CreatedAt,
-		UpdatedAt: job.UpdatedAt,
-		Tasks:     tsr,
+		ID:                job.ID,
+		Label:             job.Label,
+		State:             job.State.String(),
+		Priority:          job.Priority,
+		CreatedAt:         job.CreatedAt,
+		UpdatedAt:         job.UpdatedAt,
+		OnUpstreamFailure: job.OnUpstreamFailure,
+		Tasks:             tsr,
 	}
 }
 
@@ -468,9 +790,13 @@ func newTasksResponse(tasks []*storage.Task) []*TaskResponse {
 	for _, task := range tasks {
 		commandsResponse := newCommandResponse(task.Commands)
 		tsr = append(tsr, &TaskResponse{
-			ID:       task.ID,
-			State:    task.State.String(),
-			Commands: commandsResponse,
+			ID:        task.ID,
+			SpecID:    task.SpecID,
+			State:     task.State.String(),
+			Reason:    task.Reason,
+			DependsOn: task.DependsOn,
+			Commands:  commandsResponse,
+			Attempts:  task.Attempts,
 		})
 	}
 	return tsr
@@ -493,6 +819,8 @@ func responseFromQueue(queue *storage.Queue, pendingTasks uint, runningTasks uin
 	return &QueueResponse{
 		ID:           queue.ID,
 		Name:         queue.Name,
+		Policy:       queue.Policy,
+		State:        queue.State.String(),
 		PendingTasks: pendingTasks,
 		RunningTasks: runningTasks,
 		Nodes:        uint(len(queue.Nodes)),
@@ -500,6 +828,50 @@ func responseFromQueue(queue *storage.Queue, pendingTasks uint, runningTasks uin
 	}
 }
 
+// withDefaults is the config along with the defaults it does not set
+func withDefaults(config, defaults map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return config
+	}
+	merged := make(map[string]string, len(config)+len(defaults))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+	for key, value := range config {
+		merged[key] = value
+	}
+	return merged
+}
+
+// checkDependencies defaults the behaviour of the job on upstream failure and checks the
+// dependencies of its tasks, which name the tasks by their unique IDs
+func checkDependencies(spec *JobSpec) error {
+	if spec.OnUpstreamFailure == "" {
+		spec.OnUpstreamFailure = service.DefaultUpstreamFailure
+	} else if !service.ValidUpstreamFailure(spec.OnUpstreamFailure) {
+		return service.UnknownUpstreamFailureErr
+	}
+
+	declared := false
+	for _, taskSpec := range spec.Tasks {
+		declared = declared || len(taskSpec.DependsOn) > 0
+	}
+	if !declared {
+		return nil
+	}
+	dependsOn := make(map[string][]string, len(spec.Tasks))
+	for _, taskSpec := range spec.Tasks {
+		if taskSpec.ID == "" {
+			return errors.New("every task must have an ID when the tasks depend on each other")
+		}
+		if _, ok := dependsOn[taskSpec.ID]; ok {
+			return fmt.Errorf("Task [%s] is declared more than once", taskSpec.ID)
+		}
+		dependsOn[taskSpec.ID] = taskSpec.DependsOn
+	}
+	return service.CheckDependencies(dependsOn)
+}
+
 func extractFromSpec(spec JobSpec) *storage.Job {
 	var tasks []*storage.Task
 
@@ -508,16 +880,25 @@ func extractFromSpec(spec JobSpec) *storage.Job {
 		metadata := extractMetadata(&taskSpec)
 		commands := extractCommands(&taskSpec)
 
+		state := storage.TaskPending
+		if len(taskSpec.DependsOn) > 0 {
+			state = storage.TaskWaiting
+		}
 		tasks = append(tasks, &storage.Task{
-			Config:   configs,
-			State:    storage.TaskPending,
-			Metadata: metadata,
-			Commands: commands,
+			Config:     configs,
+			State:      state,
+			Metadata:   metadata,
+			Commands:   commands,
+			SpecID:     taskSpec.ID,
+			DependsOn:  taskSpec.DependsOn,
+			ArrayIndex: taskSpec.index,
 		})
 	}
 	return &storage.Job{
-		Label: spec.Label,
-		Tasks: tasks,
+		Label:             spec.Label,
+		Priority:          spec.Priority,
+		OnUpstreamFailure: spec.OnUpstreamFailure,
+		Tasks:             tasks,
 	}
 }
 
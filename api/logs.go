@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"github.com/ufcg-lsd/arrebol-pb/storage/blob"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+func (a *HttpApi) RetrieveCommandLogs(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation GET /v1/queues/{queue_id}/jobs/{job_id}/tasks/{task_id}/commands/{command_id}/logs retrieveCommandLogs
+	//
+	// Retrieve the output of a command. Partial content may be requested through the Range header.
+	// ---
+	// produces:
+	// - text/plain
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The job id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The task id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The command id
+	//   required: true
+	//   type: string
+	// - name: stream
+	//   in: query
+	//   description: The output stream, stdout (default) or stderr
+	//   type: string
+	// - name: tail
+	//   in: query
+	//   description: Return only the last lines of the output
+	//   type: integer
+	// responses:
+	//   '200':
+	//     description: The output of the command
+	//   '206':
+	//     description: The requested range of the output
+	//   '404':
+	//     description: The command or its output was not found
+	params := mux.Vars(r)
+
+	queueID, _ := strconv.Atoi(params["qid"])
+	jobID, _ := strconv.Atoi(params["jid"])
+	taskID, _ := strconv.Atoi(params["tid"])
+	cmdID, _ := strconv.Atoi(params["cid"])
+
+	stream := r.URL.Query().Get("stream")
+	if stream == "" {
+		stream = driver.Stdout
+	}
+	if stream != driver.Stdout && stream != driver.Stderr {
+		Write(w, http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Unknown stream [%s], use %s or %s", stream, driver.Stdout, driver.Stderr),
+			Status:  http.StatusBadRequest,
+		})
+		return
+	}
+
+	tail := -1
+	if v := r.URL.Query().Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			Write(w, http.StatusBadRequest, ErrorResponse{
+				Message: "tail must be a non-negative integer",
+				Status:  http.StatusBadRequest,
+			})
+			return
+		}
+		tail = n
+	}
+
+	job, err := a.storage.RetrieveJobByQueue(uint(jobID), uint(queueID))
+	if err != nil {
+		Write(w, http.StatusNotFound, ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusNotFound,
+		})
+		return
+	}
+
+	cmd := findCommand(job, uint(taskID), uint(cmdID))
+	if cmd == nil {
+		Write(w, http.StatusNotFound, ErrorResponse{
+			Message: fmt.Sprintf("Command [%d] not found on task [%d] of job [%d]", cmdID, taskID, jobID),
+			Status:  http.StatusNotFound,
+		})
+		return
+	}
+
+	if blob.DefaultStore == nil {
+		Write(w, http.StatusNotFound, ErrorResponse{
+			Message: blob.NotFoundErr.Error(),
+			Status:  http.StatusNotFound,
+		})
+		return
+	}
+
+	b, err := blob.DefaultStore.Open(driver.LogKey(cmd.TaskID, cmd.ID, stream))
+	if err == blob.NotFoundErr {
+		Write(w, http.StatusNotFound, ErrorResponse{
+			Message: fmt.Sprintf("There is no %s of command [%d]", stream, cmdID),
+			Status:  http.StatusNotFound,
+		})
+		return
+	} else if err != nil {
+		Write(w, http.StatusInternalServerError, ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		})
+		return
+	}
+	defer b.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if tail < 0 {
+		http.ServeContent(w, r, "", b.ModTime(), b)
+		return
+	}
+
+	// the logs are capped on capture, so reading the whole of it is bounded
+	content, err := ioutil.ReadAll(b)
+	if err != nil {
+		Write(w, http.StatusInternalServerError, ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		})
+		return
+	}
+	http.ServeContent(w, r, "", b.ModTime(), bytes.NewReader(lastLines(content, tail)))
+}
+
+func findCommand(job *storage.Job, taskID, cmdID uint) *storage.Command {
+	for _, task := range job.Tasks {
+		if task.ID != taskID {
+			continue
+		}
+		for _, cmd := range task.Commands {
+			if cmd.ID == cmdID {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+// lastLines returns the last n lines of the content
+func lastLines(content []byte, n int) []byte {
+	if n == 0 {
+		return nil
+	}
+	i := len(content)
+	if i > 0 && content[i-1] == '\n' {
+		i--
+	}
+	for ; n > 0; n-- {
+		i = bytes.LastIndexByte(content[:i], '\n')
+		if i < 0 {
+			return content
+		}
+	}
+	return content[i+1:]
+}
@@ -0,0 +1,29 @@
+package api
+
+import "testing"
+
+func TestLastLines(t *testing.T) {
+	cases := []struct {
+		content string
+		n       int
+		want    string
+	}{
+		{"", 0, ""},
+		{"", 3, ""},
+		{"\n", 1, "\n"},
+		{"a\nb\nc", 0, ""},
+		{"a\nb\nc", 1, "c"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\nc\n", 1, "c\n"},
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc\n", 3, "a\nb\nc\n"},
+		{"a\nb\nc\n", 10, "a\nb\nc\n"},
+		{"a\nb\nc", 10, "a\nb\nc"},
+		{"a\n\n\n", 2, "\n\n"},
+	}
+	for _, c := range cases {
+		if got := string(lastLines([]byte(c.content), c.n)); got != c.want {
+			t.Errorf("lastLines(%q, %d) = %q, want %q", c.content, c.n, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/go-units"
+	"github.com/gorilla/mux"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
+	"github.com/ufcg-lsd/arrebol-pb/docker"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// swagger:model nodeSpec
+type NodeSpec struct {
+	// address of the docker daemon of the node, e.g. tcp://10.0.0.2:5555. Raw nodes run their
+	// tasks on the host of the server.
+	// required: false
+	Address string `json:"Address"`
+	// driver running the tasks, raw (the default) or docker
+	// required: false
+	Driver string `json:"Driver"`
+	// how many workers the node runs, sharing its capacity evenly, one by default
+	// required: false
+	Workers int `json:"Workers"`
+	// how many tasks each worker runs at once, one by default
+	// required: false
+	Slots int `json:"Slots"`
+	// cores of the node, not accounted when left out
+	// required: false
+	CPU float64 `json:"CPU"`
+	// memory of the node, e.g. 16GiB, not accounted when left out
+	// required: false
+	Memory string `json:"Memory"`
+	// disk of the node, e.g. 100GiB, not accounted when left out
+	// required: false
+	Disk string `json:"Disk"`
+	// labels the constraints of the tasks are matched against
+	// required: false
+	Labels map[string]string `json:"Labels"`
+}
+
+type NodeResponse struct {
+	ID        uint              `json:"ID"`
+	QueueID   uint              `json:"QueueID"`
+	State     string            `json:"State"`
+	Address   string            `json:"Address"`
+	Driver    string            `json:"Driver"`
+	Workers   int               `json:"Workers"`
+	Slots     int               `json:"Slots"`
+	CPU       float64           `json:"CPU"`
+	Memory    int64             `json:"Memory"`
+	Disk      int64             `json:"Disk"`
+	Labels    map[string]string `json:"Labels,omitempty"`
+	CreatedAt time.Time         `json:"CreatedAt"`
+}
+
+func (a *HttpApi) AddNode(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation POST /v1/queues/{queue_id}/nodes addNode
+	//
+	// Registers a node to the queue, whose workers start taking its tasks
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: body
+	//   in: body
+	//   description: The node payload
+	//   required: true
+	//   schema:
+	//       "$ref": "#/definitions/nodeSpec"
+	// responses:
+	//   '201':
+	//     description: The node id
+	//     schema:
+	//       "$ref": "#/definitions/GenericIdResponse"
+	//   '400':
+	//     description: The driver, the amounts of workers or slots, or the capacity is malformed
+	//   '404':
+	//     description: The queue does not exist
+	params := mux.Vars(r)
+	queueID, _ := strconv.Atoi(params["qid"])
+
+	var spec NodeSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, MalformedBodyErr)
+		return
+	}
+	if _, err := a.storage.RetrieveQueue(uint(queueID)); err != nil {
+		writeError(w, queueNotFound(queueID))
+		return
+	}
+
+	node, err := newNode(uint(queueID), &spec)
+	if err != nil {
+		writeError(w, badRequest(err))
+		return
+	}
+	if err := a.storage.SaveNode(node); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := a.arrebol.AddNode(node); err != nil {
+		// the supervisor of the queue hires the workers of its nodes once it starts
+		log.Printf("Node [%d] waits for queue [%d] to have a supervisor: %s", node.ID, queueID, err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"ID": "%d"}`, node.ID)
+}
+
+func (a *HttpApi) RetrieveNodes(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation GET /v1/queues/{queue_id}/nodes retrieveNodes
+	//
+	// Retrieve the nodes of a queue
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: The nodes
+	//   '404':
+	//     description: The queue does not exist
+	params := mux.Vars(r)
+	queueID, _ := strconv.Atoi(params["qid"])
+
+	if _, err := a.storage.RetrieveQueue(uint(queueID)); err != nil {
+		writeError(w, queueNotFound(queueID))
+		return
+	}
+	nodes, err := a.storage.RetrieveNodesByQueueID(uint(queueID))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response := make([]*NodeResponse, 0, len(nodes))
+	for _, node := range nodes {
+		response = append(response, newNodeResponse(node))
+	}
+	Write(w, http.StatusOK, response)
+}
+
+func (a *HttpApi) RetrieveNode(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation GET /v1/queues/{queue_id}/nodes/{node_id} retrieveNode
+	//
+	// Retrieve a node of a queue
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The node id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: The node
+	//   '404':
+	//     description: The node does not exist
+	node, ok := a.retrieveNode(w, r)
+	if !ok {
+		return
+	}
+	Write(w, http.StatusOK, newNodeResponse(node))
+}
+
+func (a *HttpApi) DeleteNode(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation DELETE /v1/queues/{queue_id}/nodes/{node_id} deleteNode
+	//
+	// Drain a node: its workers take no new tasks, and it is removed once the ones they run end
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The node id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '202':
+	//     description: The draining node
+	//   '404':
+	//     description: The node does not exist
+	//   '409':
+	//     description: The node is already draining
+	node, ok := a.retrieveNode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.arrebol.DrainNode(node); err != nil {
+		writeError(w, err)
+	} else {
+		Write(w, http.StatusAccepted, newNodeResponse(node))
+	}
+}
+
+// retrieveNode retrieves the node the request names, writing the error when it can not
+func (a *HttpApi) retrieveNode(w http.ResponseWriter, r *http.Request) (*storage.ResourceNode, bool) {
+	params := mux.Vars(r)
+	queueID, _ := strconv.Atoi(params["qid"])
+	nodeID, _ := strconv.Atoi(params["nid"])
+
+	node, err := a.storage.RetrieveNode(uint(nodeID), uint(queueID))
+	if err != nil {
+		writeError(w, notFound("Node with ID %d not found on queue %d", nodeID, queueID))
+		return nil, false
+	}
+	return node, true
+}
+
+// newNode validates the spec, defaulting what it leaves out, and creates the node it describes
+func newNode(queueID uint, spec *NodeSpec) (*storage.ResourceNode, error) {
+	if spec.Driver == "" {
+		spec.Driver = service.RawNodeDriver
+	}
+	if !service.ValidNodeDriver(spec.Driver) {
+		return nil, fmt.Errorf("Unknown driver [%s], use raw or docker", spec.Driver)
+	}
+	if spec.Driver == service.DockerNodeDriver {
+		if err := docker.ValidHost(spec.Address); err != nil {
+			return nil, fmt.Errorf("Malformed docker address [%s]: %s", spec.Address, err.Error())
+		}
+	}
+	if spec.Workers == 0 {
+		spec.Workers = 1
+	}
+	if spec.Slots == 0 {
+		spec.Slots = 1
+	}
+	if spec.Workers < 0 || spec.Slots < 0 {
+		return nil, errors.New("the amounts of workers and slots must be positive")
+	}
+	if spec.CPU < 0 {
+		return nil, errors.New("the CPU must be a positive number of cores, e.g. 2 or 0.5")
+	}
+
+	node := &storage.ResourceNode{
+		QueueID: queueID,
+		State:   storage.Idle,
+		Address: spec.Address,
+		Driver:  spec.Driver,
+		Workers: spec.Workers,
+		Slots:   spec.Slots,
+		CPU:     spec.CPU,
+		Labels:  spec.Labels,
+	}
+	var err error
+	if node.Memory, err = parseSize("Memory", spec.Memory); err != nil {
+		return nil, err
+	}
+	if node.Disk, err = parseSize("Disk", spec.Disk); err != nil {
+		return nil, err
+	}
+	for key := range spec.Labels {
+		if strings.TrimSpace(key) == "" {
+			return nil, errors.New("the labels must have a non empty key")
+		}
+	}
+	return node, nil
+}
+
+// parseSize reads a size such as 16GiB, zero when it is left out
+func parseSize(name, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(value)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("the %s must be a positive size, e.g. 16GiB", name)
+	}
+	return size, nil
+}
+
+func newNodeResponse(node *storage.ResourceNode) *NodeResponse {
+	return &NodeResponse{
+		ID:        node.ID,
+		QueueID:   node.QueueID,
+		State:     node.State.String(),
+		Address:   node.Address,
+		Driver:    node.Driver,
+		Workers:   node.Workers,
+		Slots:     node.Slots,
+		CPU:       node.CPU,
+		Memory:    node.Memory,
+		Disk:      node.Disk,
+		Labels:    node.Labels,
+		CreatedAt: node.CreatedAt,
+	}
+}
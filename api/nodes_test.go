@@ -0,0 +1,37 @@
+package api
+
+import (
+	"github.com/docker/go-units"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
+	"testing"
+)
+
+func TestNewNode(t *testing.T) {
+	node, err := newNode(1, &NodeSpec{Address: "tcp://10.0.0.2:5555", Driver: "docker", CPU: 4, Memory: "8GiB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.QueueID != 1 || node.Driver != service.DockerNodeDriver || node.Memory != 8*units.GiB || node.Disk != 0 {
+		t.Errorf("node = %+v", node)
+	}
+	// what the spec leaves out is defaulted
+	if node.Workers != 1 || node.Slots != 1 {
+		t.Errorf("got %d workers with %d slots, want 1 with 1", node.Workers, node.Slots)
+	}
+
+	rejected := map[string]*NodeSpec{
+		"unknown driver":   {Driver: "vm"},
+		"negative workers": {Workers: -1},
+		"negative slots":   {Slots: -2},
+		"negative cpu":     {CPU: -1},
+		"malformed memory": {Memory: "lots"},
+		"malformed disk":   {Disk: "-1GiB"},
+		"empty label key":  {Labels: map[string]string{" ": "a"}},
+		"malformed host":   {Driver: "docker", Address: "10.0.0.2"},
+	}
+	for name, spec := range rejected {
+		if _, err := newNode(1, spec); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
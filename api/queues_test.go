@@ -0,0 +1,64 @@
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckQueueName(t *testing.T) {
+	for _, name := range []string{"Default", "nightly-builds", "gpu_a100", "v1.2"} {
+		if err := checkQueueName(name); err != nil {
+			t.Errorf("%q: %s", name, err.Error())
+		}
+	}
+	for _, name := range []string{"", "42", "-leading", "with space", "a/b", string(make([]byte, 65))} {
+		if err := checkQueueName(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestResolveQueue(t *testing.T) {
+	names := map[string]uint{"nightly": 3}
+	var looked []string
+	byName := func(name string) (uint, error) {
+		looked = append(looked, name)
+		if id, ok := names[name]; ok {
+			return id, nil
+		}
+		return 0, gorm.ErrRecordNotFound
+	}
+	var got string
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/queues/{qid}", func(w http.ResponseWriter, r *http.Request) {
+		got = mux.Vars(r)["qid"]
+	})
+	router.Use(resolveQueue(byName))
+
+	cases := []struct {
+		ref    string
+		status int
+		qid    string
+		looked bool
+	}{
+		{"7", http.StatusOK, "7", false},
+		{"nightly", http.StatusOK, "3", true},
+		{"unknown", http.StatusNotFound, "", true},
+		// a reference made only of digits is an ID, never a name
+		{"0042", http.StatusOK, "0042", false},
+	}
+	for _, c := range cases {
+		got, looked = "", nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/queues/"+c.ref, nil))
+		if w.Code != c.status || got != c.qid {
+			t.Errorf("%s: status %d with qid %q, want %d with %q", c.ref, w.Code, got, c.status, c.qid)
+		}
+		if (len(looked) > 0) != c.looked {
+			t.Errorf("%s: looked up names %q", c.ref, looked)
+		}
+	}
+}
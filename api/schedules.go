@@ -0,0 +1,394 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// swagger:model scheduleSpec
+type ScheduleSpec struct {
+	// name
+	// required: false
+	Name string `json:"Name"`
+	// cron expression telling when the job is submitted, e.g. "0 2 * * mon-fri"
+	// required: true
+	Cron string `json:"Cron"`
+	// timezone the cron expression is read in, e.g. "America/Recife", UTC by default
+	// required: false
+	Timezone string `json:"Timezone"`
+	// what happens when the schedule fires while its last job has not completed: skip (the
+	// default), allow or cancel
+	// required: false
+	Overlap string `json:"Overlap"`
+	// paused schedules do not fire
+	// required: false
+	Paused bool `json:"Paused"`
+	// the job spec of the jobs submitted, as sent to create a job
+	// required: true
+	Job json.RawMessage `json:"Job"`
+}
+
+// swagger:model SchedulePatch
+type SchedulePatch struct {
+	Cron     *string         `json:"Cron"`
+	Timezone *string         `json:"Timezone"`
+	Overlap  *string         `json:"Overlap"`
+	Paused   *bool           `json:"Paused"`
+	Job      json.RawMessage `json:"Job"`
+}
+
+type ScheduleResponse struct {
+	ID        uint                   `json:"ID"`
+	Name      string                 `json:"Name"`
+	Cron      string                 `json:"Cron"`
+	Timezone  string                 `json:"Timezone"`
+	Overlap   string                 `json:"Overlap"`
+	Paused    bool                   `json:"Paused"`
+	NextRunAt *time.Time             `json:"NextRunAt"`
+	Job       json.RawMessage        `json:"Job"`
+	Runs      []*ScheduleRunResponse `json:"Runs,omitempty"`
+}
+
+type ScheduleRunResponse struct {
+	FiredAt time.Time `json:"FiredAt"`
+	Outcome string    `json:"Outcome"`
+	JobID   *uint     `json:"JobID,omitempty"`
+	Reason  string    `json:"Reason,omitempty"`
+}
+
+func (a *HttpApi) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation POST /v1/queues/{queue_id}/schedules createSchedule
+	//
+	// Creates a schedule submitting a job to the queue each time its cron expression fires
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: body
+	//   in: body
+	//   description: The schedule payload
+	//   required: true
+	//   schema:
+	//       "$ref": "#/definitions/scheduleSpec"
+	// responses:
+	//   '201':
+	//     description: The schedule id
+	//     schema:
+	//       "$ref": "#/definitions/GenericIdResponse"
+	//   '400':
+	//     description: The cron expression, the timezone or the overlap policy is malformed
+	//   '422':
+	//     description: The job spec is invalid, the fields found invalid are listed
+	//   '404':
+	//     description: The queue does not exist
+	params := mux.Vars(r)
+	queueID, _ := strconv.Atoi(params["qid"])
+
+	var spec ScheduleSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, MalformedBodyErr)
+		return
+	}
+	if _, err := a.storage.RetrieveQueue(uint(queueID)); err != nil {
+		writeError(w, queueNotFound(queueID))
+		return
+	}
+
+	if spec.Timezone == "" {
+		spec.Timezone = "UTC"
+	}
+	if spec.Overlap == "" {
+		spec.Overlap = service.DefaultOverlap
+	}
+	schedule := &storage.Schedule{
+		QueueID:  uint(queueID),
+		Name:     spec.Name,
+		Cron:     spec.Cron,
+		Timezone: spec.Timezone,
+		Overlap:  spec.Overlap,
+		Paused:   spec.Paused,
+		Spec:     string(spec.Job),
+	}
+	if err := reschedule(schedule); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := a.storage.SaveSchedule(schedule); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"ID": "%d"}`, schedule.ID)
+}
+
+func (a *HttpApi) RetrieveSchedules(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation GET /v1/queues/{queue_id}/schedules retrieveSchedules
+	//
+	// Retrieve the schedules of a queue
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: The schedules, without their runs
+	params := mux.Vars(r)
+	queueID, _ := strconv.Atoi(params["qid"])
+
+	schedules, err := a.storage.RetrieveSchedulesByQueueID(uint(queueID))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response := make([]*ScheduleResponse, 0, len(schedules))
+	for _, schedule := range schedules {
+		response = append(response, newScheduleResponse(schedule))
+	}
+	Write(w, http.StatusOK, response)
+}
+
+func (a *HttpApi) RetrieveSchedule(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation GET /v1/queues/{queue_id}/schedules/{schedule_id} retrieveSchedule
+	//
+	// Retrieve a schedule along with its runs, the latest first, and the jobs they submitted
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The schedule id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: The schedule
+	//   '404':
+	//     description: The schedule does not exist
+	schedule, ok := a.retrieveSchedule(w, r)
+	if !ok {
+		return
+	}
+	Write(w, http.StatusOK, newScheduleResponse(schedule))
+}
+
+func (a *HttpApi) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation PATCH /v1/queues/{queue_id}/schedules/{schedule_id} updateSchedule
+	//
+	// Change a schedule, e.g. pause it with {"Paused": true} or resume it with {"Paused": false}.
+	// A schedule resumed or changed fires next as its cron expression tells from now on.
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The schedule id
+	//   required: true
+	//   type: string
+	// - name: body
+	//   in: body
+	//   description: The fields to change
+	//   required: true
+	//   schema:
+	//       "$ref": "#/definitions/SchedulePatch"
+	// responses:
+	//   '200':
+	//     description: The updated schedule
+	//   '400':
+	//     description: The cron expression, the timezone or the overlap policy is malformed
+	//   '422':
+	//     description: The job spec is invalid, the fields found invalid are listed
+	//   '404':
+	//     description: The schedule does not exist
+	var patch SchedulePatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, MalformedBodyErr)
+		return
+	}
+	schedule, ok := a.retrieveSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	if patch.Cron != nil {
+		schedule.Cron = *patch.Cron
+	}
+	if patch.Timezone != nil {
+		schedule.Timezone = *patch.Timezone
+	}
+	if patch.Overlap != nil {
+		schedule.Overlap = *patch.Overlap
+	}
+	if patch.Paused != nil {
+		schedule.Paused = *patch.Paused
+	}
+	if patch.Job != nil {
+		schedule.Spec = string(patch.Job)
+	}
+	if err := reschedule(schedule); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	runs := schedule.Runs
+	schedule.Runs = nil
+	if err := a.storage.SaveSchedule(schedule); err != nil {
+		writeError(w, err)
+		return
+	}
+	schedule.Runs = runs
+	Write(w, http.StatusOK, newScheduleResponse(schedule))
+}
+
+func (a *HttpApi) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	// swagger:operation DELETE /v1/queues/{queue_id}/schedules/{schedule_id} deleteSchedule
+	//
+	// Delete a schedule, leaving the jobs it submitted
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: The queue id
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: path
+	//   description: The schedule id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: The deleted schedule
+	//   '404':
+	//     description: The schedule does not exist
+	schedule, ok := a.retrieveSchedule(w, r)
+	if !ok {
+		return
+	}
+	if err := a.storage.DeleteSchedule(schedule.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	Write(w, http.StatusOK, newScheduleResponse(schedule))
+}
+
+// SubmitScheduled creates the job of a schedule the way CreateJob creates the jobs sent to it
+func (a *HttpApi) SubmitScheduled(schedule *storage.Schedule) (*storage.Job, error) {
+	var spec JobSpec
+	if err := json.Unmarshal([]byte(schedule.Spec), &spec); err != nil {
+		return nil, err
+	}
+	if spec.Label == "" {
+		spec.Label = schedule.Name
+	}
+	job, err := newJob(&spec)
+	if err != nil {
+		return nil, err
+	}
+	queue, err := a.storage.RetrieveQueue(schedule.QueueID)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.submitJob(queue, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// retrieveSchedule finds the schedule the request is about, writing the response when it does not exist
+func (a *HttpApi) retrieveSchedule(w http.ResponseWriter, r *http.Request) (*storage.Schedule, bool) {
+	params := mux.Vars(r)
+	queueID, _ := strconv.Atoi(params["qid"])
+	scheduleID, _ := strconv.Atoi(params["sid"])
+
+	schedule, err := a.storage.RetrieveSchedule(uint(scheduleID), uint(queueID))
+	if err != nil {
+		writeError(w, notFound("Schedule [%d] not found on queue [%d]", scheduleID, queueID))
+		return nil, false
+	}
+	return schedule, true
+}
+
+// reschedule checks the schedule, its job spec included, and sets when it fires next from now
+func reschedule(schedule *storage.Schedule) error {
+	if !service.ValidOverlap(schedule.Overlap) {
+		return service.UnknownOverlapErr
+	}
+	var spec JobSpec
+	if err := json.Unmarshal([]byte(schedule.Spec), &spec); err != nil {
+		return invalidJob(fieldError("Job", "is not a job spec: "+err.Error()))
+	}
+	if spec.Label == "" {
+		spec.Label = schedule.Name
+	}
+	if _, err := newJob(&spec); err != nil {
+		return err
+	}
+
+	next, err := service.NextRun(schedule.Cron, schedule.Timezone, time.Now())
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = &next
+	if schedule.Paused {
+		schedule.NextRunAt = nil
+	}
+	return nil
+}
+
+func newScheduleResponse(schedule *storage.Schedule) *ScheduleResponse {
+	response := &ScheduleResponse{
+		ID:        schedule.ID,
+		Name:      schedule.Name,
+		Cron:      schedule.Cron,
+		Timezone:  schedule.Timezone,
+		Overlap:   schedule.Overlap,
+		Paused:    schedule.Paused,
+		NextRunAt: schedule.NextRunAt,
+		Job:       json.RawMessage(schedule.Spec),
+	}
+	for _, run := range schedule.Runs {
+		response.Runs = append(response.Runs, &ScheduleRunResponse{
+			FiredAt: run.FiredAt,
+			Outcome: run.Outcome.String(),
+			JobID:   run.JobID,
+			Reason:  run.Reason,
+		})
+	}
+	return response
+}
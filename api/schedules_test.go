@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"testing"
+	"time"
+)
+
+func TestReschedule(t *testing.T) {
+	job := `{"Label": "nightly", "Tasks": [{"ID": "a", "Commands": ["echo ${index}"], "Array": {"Range": [1, 2]}}]}`
+
+	schedule := &storage.Schedule{Cron: "0 2 * * *", Timezone: "UTC", Overlap: "skip", Spec: job}
+	if err := reschedule(schedule); err != nil {
+		t.Fatal(err)
+	}
+	if next := *schedule.NextRunAt; next.Hour() != 2 || next.Minute() != 0 || !next.After(time.Now()) {
+		t.Errorf("next run at %s, want the next 2 AM", next)
+	}
+
+	schedule.Paused = true
+	if err := reschedule(schedule); err != nil || schedule.NextRunAt != nil {
+		t.Errorf("a paused schedule should not fire, next run at %v, %v", schedule.NextRunAt, err)
+	}
+
+	rejected := map[string]*storage.Schedule{
+		"malformed cron":   {Cron: "0 25 * * *", Timezone: "UTC", Overlap: "skip", Spec: job},
+		"unknown timezone": {Cron: "0 2 * * *", Timezone: "Mars/Olympus", Overlap: "skip", Spec: job},
+		"unknown overlap":  {Cron: "0 2 * * *", Timezone: "UTC", Overlap: "queue", Spec: job},
+		"never fires":      {Cron: "0 0 30 feb *", Timezone: "UTC", Overlap: "skip", Spec: job},
+		"malformed job":    {Cron: "0 2 * * *", Timezone: "UTC", Overlap: "skip", Spec: `{"Tasks": [{"Array": {}}]}`},
+		"no job":           {Cron: "0 2 * * *", Timezone: "UTC", Overlap: "skip"},
+	}
+	for name, schedule := range rejected {
+		if err := reschedule(schedule); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"strings"
+)
+
+// The limits on the sizes of a job spec
+const (
+	MaxLabelLength   = 255
+	MaxTaskCommands  = 1000
+	MaxCommandLength = 64 * 1024
+	MaxTaskMetadata  = 100
+)
+
+// newJob validates the job spec, expanding its task arrays, and builds the job it describes.
+// It fails with an unprocessable error listing every field found invalid.
+func newJob(spec *JobSpec) (*storage.Job, error) {
+	if errs := validateJobSpec(spec); len(errs) > 0 {
+		return nil, invalidJob(errs...)
+	}
+	if err := expandArrays(spec); err != nil {
+		return nil, invalidJob(fieldError("Tasks", err.Error()))
+	}
+
+	var errs []error
+	// the tasks expanded from an array share the errors of their config, reported once
+	reported := make(map[string]bool)
+	for i := range spec.Tasks {
+		taskSpec := &spec.Tasks[i]
+		taskSpec.Config = withDefaults(taskSpec.Config, spec.Config)
+		if err := service.ValidateTaskConfig(taskSpec.Config); err != nil {
+			err = configError(taskField(taskSpec.source, "Config"), err)
+			if !reported[err.Error()] {
+				reported[err.Error()] = true
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, invalidJob(errs...)
+	}
+	if err := checkDependencies(spec); err != nil {
+		return nil, invalidJob(fieldError("Tasks.DependsOn", err.Error()))
+	}
+	return extractFromSpec(*spec), nil
+}
+
+// validateJobSpec checks the fields of the job spec as it was sent, before its task arrays
+// are expanded, returning an error for each field found invalid
+func validateJobSpec(spec *JobSpec) []error {
+	var errs []error
+	if strings.TrimSpace(spec.Label) == "" {
+		errs = append(errs, fieldError("Label", "is required"))
+	} else if len(spec.Label) > MaxLabelLength {
+		errs = append(errs, fieldError("Label", fmt.Sprintf("must have up to %d characters", MaxLabelLength)))
+	}
+	if spec.OnUpstreamFailure != "" && !service.ValidUpstreamFailure(spec.OnUpstreamFailure) {
+		errs = append(errs, fieldError("OnUpstreamFailure", service.UnknownUpstreamFailureErr.Error()))
+	}
+	if len(spec.Tasks) == 0 {
+		errs = append(errs, fieldError("Tasks", "is required"))
+	} else if len(spec.Tasks) > MaxArrayTasks {
+		errs = append(errs, fieldError("Tasks", MaxArrayTasksErr.Error()))
+		return errs
+	}
+
+	ids := make(map[string]int)
+	for i := range spec.Tasks {
+		taskSpec := &spec.Tasks[i]
+		if taskSpec.ID != "" {
+			if first, ok := ids[taskSpec.ID]; ok {
+				errs = append(errs, fieldError(taskField(i, "ID"), fmt.Sprintf("[%s] is already the ID of Tasks[%d]", taskSpec.ID, first)))
+			} else {
+				ids[taskSpec.ID] = i
+			}
+		}
+		errs = append(errs, validateTaskSpec(i, taskSpec)...)
+	}
+	return errs
+}
+
+func validateTaskSpec(i int, spec *TaskSpec) []error {
+	var errs []error
+	if len(spec.Commands) == 0 {
+		errs = append(errs, fieldError(taskField(i, "Commands"), "is required"))
+	} else if len(spec.Commands) > MaxTaskCommands {
+		errs = append(errs, fieldError(taskField(i, "Commands"), fmt.Sprintf("must have up to %d commands", MaxTaskCommands)))
+	}
+	for j, cmd := range spec.Commands {
+		field := taskField(i, fmt.Sprintf("Commands[%d]", j))
+		if strings.TrimSpace(cmd) == "" {
+			errs = append(errs, fieldError(field, "must not be empty"))
+		} else if len(cmd) > MaxCommandLength {
+			errs = append(errs, fieldError(field, fmt.Sprintf("must have up to %d bytes", MaxCommandLength)))
+		}
+	}
+	if len(spec.Metadata) > MaxTaskMetadata {
+		errs = append(errs, fieldError(taskField(i, "Metadata"), fmt.Sprintf("must have up to %d keys", MaxTaskMetadata)))
+	}
+	for key := range spec.Metadata {
+		if strings.TrimSpace(key) == "" {
+			errs = append(errs, fieldError(taskField(i, "Metadata"), "must not have empty keys"))
+			break
+		}
+	}
+	for j, dep := range spec.DependsOn {
+		if dep == "" {
+			errs = append(errs, fieldError(taskField(i, fmt.Sprintf("DependsOn[%d]", j)), "must not be empty"))
+		}
+	}
+	if spec.Array != nil {
+		if _, err := spec.Array.parameters(MaxArrayTasks); err != nil {
+			errs = append(errs, fieldError(taskField(i, "Array"), err.Error()))
+		}
+	}
+	return errs
+}
+
+func taskField(i int, field string) string {
+	return fmt.Sprintf("Tasks[%d].%s", i, field)
+}
+
+func fieldError(field, message string) error {
+	return errors.AddErrorContext(errors.Unprocessable.Newf("%s %s", field, message), field, message)
+}
+
+// configError is the error of a malformed config, as told by its validation, on the field
+// of its key
+func configError(field string, err error) error {
+	if context := errors.GetErrorContext(err); context != nil {
+		return fieldError(field+"."+context["field"], context["message"])
+	}
+	return fieldError(field, err.Error())
+}
+
+func invalidJob(errs ...error) error {
+	return errors.Unprocessable.Aggregate("The job spec is invalid", errs...)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNewJobFieldErrors(t *testing.T) {
+	spec := &JobSpec{
+		OnUpstreamFailure: "retry",
+		Tasks: []TaskSpec{
+			{ID: "a", Commands: []string{"echo a", " "}},
+			{ID: "a", Config: map[string]string{"cpu": "lots"}},
+			{ID: "b", Commands: []string{"echo ${index}"}, Array: &ArraySpec{Range: []int{3, 1}}},
+		},
+	}
+	_, err := newJob(spec)
+	if errors.GetType(err) != errors.Unprocessable {
+		t.Fatalf("expected an unprocessable error, got %v", err)
+	}
+
+	var fields []string
+	for _, context := range errors.GetErrorContexts(err) {
+		fields = append(fields, context["field"])
+	}
+	want := []string{"Label", "OnUpstreamFailure", "Tasks[0].Commands[1]", "Tasks[1].ID", "Tasks[1].Commands", "Tasks[2].Array"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+}
+
+func TestNewJobConfigErrors(t *testing.T) {
+	spec := &JobSpec{
+		Label: "render",
+		Tasks: []TaskSpec{
+			{Commands: []string{"echo"}},
+			{Commands: []string{"echo ${index}"}, Config: map[string]string{service.CPUPropertyKey: "-${index}"}, Array: &ArraySpec{Range: []int{1, 3}}},
+		},
+	}
+	_, err := newJob(spec)
+	contexts := errors.GetErrorContexts(err)
+	// the tasks of the array report the error of their config once
+	if len(contexts) != 1 || contexts[0]["field"] != "Tasks[1].Config."+service.CPUPropertyKey {
+		t.Errorf("contexts = %v, want the one of the config of Tasks[1]", contexts)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	statuses := map[error]int{
+		MalformedBodyErr:        http.StatusBadRequest,
+		queueNotFound(3):        http.StatusNotFound,
+		service.JobCompletedErr: http.StatusConflict,
+		invalidJob(fieldError("Label", "is required")): http.StatusUnprocessableEntity,
+		service.NoSupervisorErr:                        http.StatusConflict,
+		storage.QueueNameTakenErr:                      http.StatusConflict,
+		errors.New("the database is gone"):             http.StatusInternalServerError,
+	}
+	for err, want := range statuses {
+		w := httptest.NewRecorder()
+		writeError(w, err)
+		var response ErrorResponse
+		if e := json.NewDecoder(w.Body).Decode(&response); e != nil || w.Code != want || response.Status != uint(want) {
+			t.Errorf("%v: status %d, want %d", err, w.Code, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	writeError(w, invalidJob(fieldError("Label", "is required")))
+	var response ErrorResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+	if want := []FieldError{{Field: "Label", Message: "is required"}}; !reflect.DeepEqual(response.Errors, want) {
+		t.Errorf("errors = %v, want %v", response.Errors, want)
+	}
+}
@@ -58,6 +58,7 @@ func (a *API) bootRouter() *mux.Router {
 
 	router.HandleFunc("/v1/workers/{wid}/queues/{qid}/tasks", a.GetTask).Methods(http.MethodGet)
 	router.HandleFunc("/v1/workers/{wid}/queues/{qid}/tasks", a.ReportTask).Methods(http.MethodPut)
+	router.HandleFunc("/v1/workers/{wid}/queues/{qid}/heartbeat", a.Heartbeat).Methods(http.MethodPut)
 
 	return router
 }
@@ -103,13 +104,3 @@ func (a *API) GetAvailableWorkerID(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write(json)
 }
-
-func (a *API) GetTask(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-}
-
-func (a *API) ReportTask(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-}
@@ -91,6 +91,27 @@ func (a *API) AddWorker(w http.ResponseWriter, r *http.Request) {
 	api.Write(w, http.StatusCreated, map[string]string{"arrebol-worker-token": _token.String()})
 }
 
+// Heartbeat keeps the worker alive and renews the leases of the tasks it is running.
+func (a *API) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	workerID, _, err := a.authorizeWorker(r)
+	if err != nil {
+		WriteUnauthorized(&w, err.Error())
+		return
+	}
+
+	if err = a.storage.TouchWorker(workerID); err == nil {
+		err = a.storage.RenewLeases(workerID, leaseDuration())
+	}
+	if err != nil {
+		api.Write(w, http.StatusInternalServerError, api.ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func GetHeader(r *http.Request, key string) (string, error) {
 	log.Println("Getting header [" + key + "]")
 	value := r.Header.Get(key)
@@ -107,3 +128,11 @@ func WriteBadRequest(w *http.ResponseWriter, msg string) {
 		Status:  http.StatusBadRequest,
 	})
 }
+
+func WriteUnauthorized(w *http.ResponseWriter, msg string) {
+	log.Println("Unauthorized: " + msg)
+	api.Write(*w, http.StatusUnauthorized, api.ErrorResponse{
+		Message: msg,
+		Status:  http.StatusUnauthorized,
+	})
+}
@@ -0,0 +1,276 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ufcg-lsd/arrebol-pb/api"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/auth/token"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+)
+
+const (
+	TokenHeader          string = "Arrebol-Worker-Token"
+	LeaseDurationKey     string = "TASK_LEASE_DURATION"
+	DefaultLeaseDuration        = 5 * time.Minute
+)
+
+// TaskReport is sent by a worker to inform the progress of a leased task.
+// Reporting the task as running renews its lease, while any other state releases it.
+type TaskReport struct {
+	TaskID   uint              `json:"TaskID"`
+	State    storage.TaskState `json:"State"`
+	Commands []CommandReport   `json:"Commands"`
+	// Error tells the worker, rather than the commands, failed the task, e.g. an image it could not pull
+	Error string `json:"Error,omitempty"`
+}
+
+type CommandReport struct {
+	ID       uint                 `json:"ID"`
+	State    storage.CommandState `json:"State"`
+	ExitCode int                  `json:"ExitCode"`
+}
+
+func (a *API) GetTask(w http.ResponseWriter, r *http.Request) {
+	workerID, queueID, err := a.authorizeWorker(r)
+	if err != nil {
+		WriteUnauthorized(&w, err.Error())
+		return
+	}
+
+	fits, err := a.fitsWorker(workerID)
+	if err != nil {
+		api.Write(w, http.StatusInternalServerError, api.ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		})
+		return
+	}
+
+	task, err := a.storage.LeaseTask(queueID, workerID, leaseDuration(), fits)
+	if err == storage.NoPendingTaskErr {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		api.Write(w, http.StatusInternalServerError, api.ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if _, err = a.storage.StartAttempt(task.ID, workerID); err != nil {
+		log.Printf("Unable to record the attempt of task [%d]: %s", task.ID, err.Error())
+	}
+	log.Printf("Task [%d] leased to worker [%s] until %s", task.ID, workerID, task.LeaseExpiresAt.Format(time.RFC3339))
+	events.TaskChanged(task)
+	api.Write(w, http.StatusOK, task)
+}
+
+// fitsWorker tells which tasks fit in what is left of the capacity the worker registered
+// with, once the tasks it already holds are taken, and are satisfied by its labels
+func (a *API) fitsWorker(workerID string) (func(*storage.Task) bool, error) {
+	_worker, err := a.storage.RetrieveWorker(workerID)
+	if err != nil {
+		return nil, err
+	}
+	leased, err := a.storage.RetrieveLeasedTasks(workerID)
+	if err != nil {
+		return nil, err
+	}
+	var allocated service.Resources
+	for _, task := range leased {
+		allocated = allocated.Add(service.TaskRequirements(task))
+	}
+	capacity := service.RemoteCapacity(_worker)
+	return func(task *storage.Task) bool {
+		return capacity.Fits(service.TaskRequirements(task), allocated) && service.MatchesLabels(task, _worker.Labels)
+	}, nil
+}
+
+func (a *API) ReportTask(w http.ResponseWriter, r *http.Request) {
+	var report TaskReport
+
+	workerID, queueID, err := a.authorizeWorker(r)
+	if err != nil {
+		WriteUnauthorized(&w, err.Error())
+		return
+	}
+
+	if err = json.NewDecoder(r.Body).Decode(&report); err != nil {
+		WriteBadRequest(&w, WrongBodyMsg+": "+err.Error())
+		return
+	}
+
+	task, err := a.storage.RetrieveTaskByQueue(report.TaskID, queueID)
+	if err != nil {
+		api.Write(w, http.StatusNotFound, api.ErrorResponse{
+			Message: fmt.Sprintf("Task [%d] not found on queue [%d]", report.TaskID, queueID),
+			Status:  http.StatusNotFound,
+		})
+		return
+	}
+
+	if task.State == storage.TaskCancelled {
+		api.Write(w, http.StatusGone, api.ErrorResponse{
+			Message: fmt.Sprintf("Task [%d] was cancelled", task.ID),
+			Status:  http.StatusGone,
+		})
+		return
+	}
+
+	if task.WorkerID != workerID || task.State != storage.TaskRunning {
+		api.Write(w, http.StatusConflict, api.ErrorResponse{
+			Message: storage.LeaseNotHeldErr.Error(),
+			Status:  http.StatusConflict,
+		})
+		return
+	}
+
+	if err = a.updateCommands(task, report.Commands); err != nil {
+		WriteBadRequest(&w, err.Error())
+		return
+	}
+
+	switch report.State {
+	case storage.TaskRunning:
+		err = a.storage.RenewLease(task.ID, workerID, leaseDuration())
+	case storage.TaskPending, storage.TaskFinished, storage.TaskFailed:
+		err = a.release(task, workerID, report)
+	default:
+		WriteBadRequest(&w, fmt.Sprintf("Unknown task state [%d]", report.State))
+		return
+	}
+
+	if err == storage.LeaseNotHeldErr {
+		api.Write(w, http.StatusConflict, api.ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusConflict,
+		})
+		return
+	}
+	if err != nil {
+		api.Write(w, http.StatusInternalServerError, api.ErrorResponse{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		})
+		return
+	}
+
+	log.Printf("Worker [%s] reported task [%d] as %s", workerID, task.ID, report.State.String())
+	task, _ = a.storage.RetrieveTaskByQueue(task.ID, queueID)
+	if report.State != storage.TaskRunning {
+		events.TaskChanged(task)
+	}
+	api.Write(w, http.StatusOK, task)
+}
+
+// release ends the lease of the task along with its attempt, giving the task back to the
+// queue when it failed and its retry policy allows another attempt
+func (a *API) release(task *storage.Task, workerID string, report TaskReport) error {
+	attempt, err := a.storage.RetrieveOpenAttempt(task.ID, workerID)
+	if err != nil {
+		log.Printf("Task [%d] has no attempt open by worker [%s]", task.ID, workerID)
+		return a.storage.ReleaseTask(task.ID, workerID, report.State)
+	}
+
+	task.State = report.State
+	attempt.Infrastructure = report.State == storage.TaskFailed && report.Error != ""
+	attempt.Error = report.Error
+	if err = a.storage.EndAttempt(attempt, task); err != nil {
+		return err
+	}
+
+	if attempts, err := a.storage.RetrieveAttempts(task.ID); err == nil {
+		if wait, ok := service.TaskRetryPolicy(task).Next(attempts); ok {
+			log.Printf("Task [%d] failed its attempt %d, retrying in %s", task.ID, len(attempts), wait)
+			return a.storage.RetryTask(task.ID, workerID, time.Now().Add(wait))
+		}
+	}
+	return a.storage.ReleaseTask(task.ID, workerID, report.State)
+}
+
+func (a *API) updateCommands(task *storage.Task, reports []CommandReport) error {
+	commands := make(map[uint]*storage.Command)
+	for _, cmd := range task.Commands {
+		commands[cmd.ID] = cmd
+	}
+
+	for _, report := range reports {
+		if _, ok := commands[report.ID]; !ok {
+			return fmt.Errorf("Command [%d] does not belong to task [%d]", report.ID, task.ID)
+		}
+		if report.State > storage.CmdTimedOut {
+			return fmt.Errorf("Unknown state [%d] of command [%d]", report.State, report.ID)
+		}
+	}
+
+	for _, report := range reports {
+		cmd := commands[report.ID]
+		changed := cmd.State != report.State
+		cmd.State = report.State
+		cmd.ExitCode = report.ExitCode
+		if err := a.storage.SaveCommand(cmd); err != nil {
+			return err
+		}
+		if changed {
+			events.CommandChanged(task.JobID, cmd)
+		}
+	}
+	return nil
+}
+
+// authorizeWorker checks that the request comes from a worker that has joined the queue
+// of the route, returning the worker and queue IDs.
+func (a *API) authorizeWorker(r *http.Request) (string, uint, error) {
+	params := mux.Vars(r)
+
+	rawToken, err := GetHeader(r, TokenHeader)
+	if err != nil {
+		return "", 0, err
+	}
+
+	_token := token.Token(rawToken)
+	if !_token.IsValid() {
+		return "", 0, errors.New("The token is invalid or has expired")
+	}
+
+	workerID, err := _token.GetWorkerId()
+	if err != nil {
+		return "", 0, err
+	}
+	if workerID != params["wid"] {
+		return "", 0, errors.New("The token does not belong to worker [" + params["wid"] + "]")
+	}
+
+	queueID, err := a.storage.ResolveQueueID(params["qid"])
+	if err != nil {
+		return "", 0, errors.New("The queue [" + params["qid"] + "] does not exist")
+	}
+
+	_worker, err := a.storage.RetrieveWorker(workerID)
+	if err != nil {
+		return "", 0, errors.New("The worker [" + workerID + "] has not joined")
+	}
+	if _worker.QueueID != queueID {
+		return "", 0, errors.New("The worker [" + workerID + "] has not joined the queue [" + params["qid"] + "]")
+	}
+
+	return workerID, queueID, nil
+}
+
+func leaseDuration() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(LeaseDurationKey)); err == nil && d > 0 {
+		return d
+	}
+	return DefaultLeaseDuration
+}
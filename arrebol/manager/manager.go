@@ -3,6 +3,7 @@ package manager
 import (
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/ufcg-lsd/arrebol-pb/arrebol/worker"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
@@ -24,6 +25,8 @@ func NewManager(storage *storage.Storage) *Manager {
 func (m *Manager) Join(w worker.Worker) (uint, error) {
 	queueId := m.selectQueue(w)
 	w.QueueID = queueId
+	w.State = worker.Alive
+	w.LastSeen = time.Now()
 	queue, err := m.storage.RetrieveQueue(queueId)
 	if err != nil {
 		return 0, err
@@ -0,0 +1,358 @@
+// Package constraint parses the expressions tasks use to choose the labels of the workers
+// running them, e.g. `zone == "a" && gpu in (t4, a100) && !preemptible`.
+//
+// An expression combines, with &&, || and !, comparisons on the value of a label:
+//
+//	key == value       the label is set to the value
+//	key != value       the label is not set to the value, or is not set at all
+//	key in (a, b)      the label is set to one of the values
+//	key notin (a, b)   the label is not set to any of the values
+//	key                the label is set
+//
+// Values are bare words or double-quoted strings. && binds tighter than ||.
+package constraint
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed constraint
+type Expression interface {
+	// Matches tells if the labels satisfy the expression
+	Matches(labels map[string]string) bool
+	String() string
+}
+
+// Parse reads the expression, failing on the first token out of place
+func Parse(s string) (Expression, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != eof {
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+	return expr, nil
+}
+
+type and struct{ left, right Expression }
+
+func (e and) Matches(labels map[string]string) bool {
+	return e.left.Matches(labels) && e.right.Matches(labels)
+}
+
+func (e and) String() string {
+	return e.left.String() + " && " + e.right.String()
+}
+
+type or struct{ left, right Expression }
+
+func (e or) Matches(labels map[string]string) bool {
+	return e.left.Matches(labels) || e.right.Matches(labels)
+}
+
+func (e or) String() string {
+	return "(" + e.left.String() + " || " + e.right.String() + ")"
+}
+
+type not struct{ expr Expression }
+
+func (e not) Matches(labels map[string]string) bool {
+	return !e.expr.Matches(labels)
+}
+
+func (e not) String() string {
+	return "!(" + e.expr.String() + ")"
+}
+
+type equals struct{ key, value string }
+
+func (e equals) Matches(labels map[string]string) bool {
+	value, ok := labels[e.key]
+	return ok && value == e.value
+}
+
+func (e equals) String() string {
+	return e.key + " == " + quote(e.value)
+}
+
+type in struct {
+	key    string
+	values []string
+}
+
+func (e in) Matches(labels map[string]string) bool {
+	value, ok := labels[e.key]
+	if !ok {
+		return false
+	}
+	for _, v := range e.values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (e in) String() string {
+	values := make([]string, len(e.values))
+	for i, v := range e.values {
+		values[i] = quote(v)
+	}
+	sort.Strings(values)
+	return e.key + " in (" + strings.Join(values, ", ") + ")"
+}
+
+type exists struct{ key string }
+
+func (e exists) Matches(labels map[string]string) bool {
+	_, ok := labels[e.key]
+	return ok
+}
+
+func (e exists) String() string {
+	return e.key
+}
+
+func quote(value string) string {
+	for _, r := range value {
+		if !isWord(r) {
+			return strconv.Quote(value)
+		}
+	}
+	if value == "" || value == "in" || value == "notin" {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+type kind int
+
+const (
+	eof kind = iota
+	word
+	str
+	op
+)
+
+type token struct {
+	kind  kind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case eof:
+		return "end of the expression"
+	case str:
+		return strconv.Quote(t.value)
+	default:
+		return "[" + t.value + "]"
+	}
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-/:", r)
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case isWord(r):
+			start := i
+			for i < len(runes) && isWord(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{word, string(runes[start:i]), start})
+		case r == '"':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			value, err := strconv.Unquote(string(runes[start:i]))
+			if err != nil {
+				return nil, fmt.Errorf("malformed string at position %d", start)
+			}
+			tokens = append(tokens, token{str, value, start})
+		default:
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch {
+			case two == "==" || two == "!=" || two == "&&" || two == "||":
+				tokens = append(tokens, token{op, two, i})
+				i += 2
+			case strings.ContainsRune("!(),", r):
+				tokens = append(tokens, token{op, string(r), i})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+		}
+	}
+	return append(tokens, token{eof, "", len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != eof {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) accept(operator string) bool {
+	if t := p.peek(); t.kind == op && t.value == operator {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(operator string) error {
+	if !p.accept(operator) {
+		t := p.peek()
+		return fmt.Errorf("expected [%s], found %s at position %d", operator, t, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) or() (Expression, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Expression, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (Expression, error) {
+	if p.accept("!") {
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{expr}, nil
+	}
+	if p.accept("(") {
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Expression, error) {
+	t := p.take()
+	if t.kind != word {
+		return nil, fmt.Errorf("expected a label, found %s at position %d", t, t.pos)
+	}
+	key := t.value
+
+	switch next := p.peek(); {
+	case next.kind == op && (next.value == "==" || next.value == "!="):
+		p.take()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if next.value == "!=" {
+			return not{equals{key, value}}, nil
+		}
+		return equals{key, value}, nil
+	case next.kind == word && (next.value == "in" || next.value == "notin"):
+		p.take()
+		values, err := p.values()
+		if err != nil {
+			return nil, err
+		}
+		if next.value == "notin" {
+			return not{in{key, values}}, nil
+		}
+		return in{key, values}, nil
+	default:
+		return exists{key}, nil
+	}
+}
+
+func (p *parser) value() (string, error) {
+	t := p.take()
+	if t.kind != word && t.kind != str {
+		return "", fmt.Errorf("expected a value, found %s at position %d", t, t.pos)
+	}
+	return t.value, nil
+}
+
+func (p *parser) values() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return values, p.expect(")")
+}
@@ -0,0 +1,75 @@
+package constraint
+
+import (
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	labels := map[string]string{"zone": "a", "gpu": "t4", "arch": "x86_64", "name": "node 1"}
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{`zone == a`, true},
+		{`zone == "b"`, false},
+		{`zone != b`, true},
+		{`region != b`, true},
+		{`gpu in (t4, a100)`, true},
+		{`gpu notin (t4, a100)`, false},
+		{`region in (a)`, false},
+		{`gpu`, true},
+		{`!preemptible`, true},
+		{`zone == b || gpu == t4 && arch == x86_64`, true},
+		{`(zone == b || gpu == t4) && arch == arm64`, false},
+		{`!(zone == a && gpu == t4)`, false},
+		{`name == "node 1"`, true},
+	}
+	for _, c := range cases {
+		expr, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+		if got := expr.Matches(labels); got != c.match {
+			t.Errorf("%s matches = %t, want %t", c.expr, got, c.match)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`zone ==`,
+		`zone == a &&`,
+		`(zone == a`,
+		`zone in a, b`,
+		`zone in ()`,
+		`zone = a`,
+		`zone == "a`,
+		`zone == a b`,
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("%q should not parse", s)
+		}
+	}
+}
+
+func TestStringParsesBack(t *testing.T) {
+	labels := map[string]string{"zone": "a", "gpu": "t4"}
+	for _, s := range []string{
+		`zone == a && !(gpu in (a100, t4) || spot)`,
+		`zone != "two words" || gpu notin (t4)`,
+	} {
+		expr, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := Parse(expr.String())
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		if again.String() != expr.String() || again.Matches(labels) != expr.Matches(labels) {
+			t.Errorf("%s parsed back as %s", expr, again)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package cron parses the cron expressions telling when the schedules of a queue fire,
+// e.g. `30 2 * * mon-fri` for half past two on weekdays.
+//
+// An expression has five fields, the minute, the hour, the day of the month, the month and
+// the day of the week, each one a comma-separated list of:
+//
+//	5          the value
+//	1-5        the values in the range
+//	1-30/2     every 2nd value in the range
+//	*/15       every 15th value, from the first one
+//	*          every value
+//
+// Months and days of the week may be named by their first three letters, and Sunday is
+// either 0 or 7. When both the day of the month and the day of the week are restricted,
+// a day matching either one fires. The macros @yearly, @monthly, @weekly, @daily and
+// @hourly stand for their usual expressions.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Horizon bounds how far ahead the next firing is looked for, so that expressions such as
+// `0 0 30 feb *` do not search forever
+const Horizon = 5 * 366 * 24 * time.Hour
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	minutes = field{name: "minute", min: 0, max: 59}
+	hours   = field{name: "hour", min: 0, max: 23}
+	days    = field{name: "day of the month", min: 1, max: 31}
+	months  = field{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	weekdays = field{name: "day of the week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Expression is a parsed cron expression
+type Expression struct {
+	source     string
+	minute     uint64
+	hour       uint64
+	day        uint64
+	month      uint64
+	weekday    uint64
+	anyDay     bool
+	anyWeekday bool
+}
+
+// Parse reads the expression, failing on the first field out of place
+func Parse(s string) (*Expression, error) {
+	source := strings.TrimSpace(s)
+	expanded := source
+	if macro, ok := macros[strings.ToLower(source)]; ok {
+		expanded = macro
+	}
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, the minute, hour, day of the month, month and day of the week, found %d", len(fields))
+	}
+
+	e := &Expression{source: source}
+	var err error
+	if e.minute, err = minutes.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if e.hour, err = hours.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if e.day, err = days.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if e.month, err = months.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if e.weekday, err = weekdays.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	// Sunday is both 0 and 7
+	if e.weekday&(1<<7) != 0 {
+		e.weekday |= 1
+	}
+	e.anyDay = strings.HasPrefix(fields[2], "*")
+	e.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return e, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Next is the first time after t the expression fires, in the location of t. It is the zero
+// time when the expression does not fire within the horizon.
+func (e *Expression) Next(t time.Time) time.Time {
+	loc := t.Location()
+	// the firings are on the minute, the first one strictly after t
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(Horizon)
+
+	for t.Before(end) {
+		if !has(e.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !e.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !has(e.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !has(e.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay tells if the expression fires on the day of t. When both day fields are restricted,
+// either one matching is enough, as in cron; a field starting with * is not restricted, even with a step.
+func (e *Expression) matchesDay(t time.Time) bool {
+	day, weekday := has(e.day, t.Day()), has(e.weekday, int(t.Weekday()))
+	if e.anyDay || e.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+func has(set uint64, value int) bool {
+	return set&(1<<uint(value)) != 0
+}
+
+// parse reads a field into the set of its values
+func (f field) parse(s string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		span, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			span = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("malformed step [%s] of the %s", part[i+1:], f.name)
+			}
+		}
+
+		var first, last int
+		switch {
+		case span == "*":
+			first, last = f.min, f.max
+		case strings.Contains(span, "-"):
+			bounds := strings.SplitN(span, "-", 2)
+			var err error
+			if first, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if last, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("the range [%s] of the %s is reversed", span, f.name)
+			}
+		default:
+			var err error
+			if first, err = f.value(span); err != nil {
+				return 0, err
+			}
+			last = first
+			if step > 1 {
+				// a single value with a step runs up to the end, e.g. 5/15
+				last = f.max
+			}
+		}
+		for v := first; v <= last; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			// the names of the months start at one, the ones of the days at zero
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("[%s] is not a %s, between %d and %d", s, f.name, f.min, f.max)
+	}
+	return v, nil
+}
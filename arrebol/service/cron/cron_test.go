@@ -0,0 +1,87 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, time.January, 31, 10, 17, 42, 0, time.UTC)
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, time.February, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, time.February, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * sat,sun", time.Date(2024, time.February, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"5/20 10 * * *", time.Date(2024, time.January, 31, 10, 25, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+		if next := e.Next(from); !next.Equal(c.next) {
+			t.Errorf("%s: next = %s, want %s", c.expr, next, c.next)
+		}
+	}
+}
+
+func TestNextWithStepDays(t *testing.T) {
+	// Monday, January 1st and Tuesday, January 2nd
+	monday := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tuesday := monday.AddDate(0, 0, 1)
+	cases := []struct {
+		expr string
+		from time.Time
+		next time.Time
+	}{
+		{"0 0 */2 * *", monday, time.Date(2024, time.January, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * *", tuesday, time.Date(2024, time.January, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * */2", monday, time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * */2", tuesday, time.Date(2024, time.January, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * */3", monday, time.Date(2024, time.January, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * 1", monday, time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+		if next := e.Next(c.from); !next.Equal(c.next) {
+			t.Errorf("%s from %s: next = %s, want %s", c.expr, c.from, next, c.next)
+		}
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	e, _ := Parse("0 2 * * *")
+	next := e.Next(time.Date(2024, time.January, 31, 10, 0, 0, 0, time.UTC).In(loc))
+	if want := time.Date(2024, time.February, 1, 5, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next = %s, want %s", next, want)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "5-1 * * * *", "*/0 * * * *", "* * * foo *", "@often"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
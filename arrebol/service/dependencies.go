@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"sort"
+	"strings"
+)
+
+// The behaviours a job may have, on the tasks depending on one that failed
+const (
+	// SkipDownstream skips the tasks depending, directly or not, on the failed one
+	SkipDownstream = "skip"
+	// FailJob fails the job, skipping each of its tasks that has not started
+	FailJob = "fail"
+	// ContinueDownstream runs the depending tasks once their dependencies completed, finished or not
+	ContinueDownstream     = "continue"
+	DefaultUpstreamFailure = SkipDownstream
+)
+
+var UnknownUpstreamFailureErr = errors.BadRequest.New("unknown behaviour on upstream failure, use one of skip, fail or continue")
+
+// ValidUpstreamFailure tells if a job may have the behaviour on upstream failure
+func ValidUpstreamFailure(name string) bool {
+	switch name {
+	case SkipDownstream, FailJob, ContinueDownstream:
+		return true
+	default:
+		return false
+	}
+}
+
+// CheckDependencies checks that the tasks of a job, mapped from their spec IDs to the ones
+// they depend on, only depend on tasks of the job and never, even through others, on themselves
+func CheckDependencies(dependsOn map[string][]string) error {
+	ids := make([]string, 0, len(dependsOn))
+	for id, deps := range dependsOn {
+		for _, dep := range deps {
+			if _, ok := dependsOn[dep]; !ok {
+				return errors.BadRequest.Newf("Task [%s] depends on the unknown task [%s]", id, dep)
+			}
+		}
+		ids = append(ids, id)
+	}
+	// visited in order, so that the same cycle is always the one reported
+	sort.Strings(ids)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(dependsOn))
+	var path []string
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visited:
+			return nil
+		case visiting:
+			for i, p := range path {
+				if p == id {
+					cycle := append(append([]string{}, path[i:]...), id)
+					return errors.BadRequest.Newf("Tasks [%s] depend on each other", strings.Join(cycle, "] -> ["))
+				}
+			}
+		}
+		state[id] = visiting
+		path = append(path, id)
+		for _, dep := range dependsOn[id] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		return nil
+	}
+	for _, id := range ids {
+		if err := visit(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verdict is what the dependencies of a waiting task tell to do with it
+type verdict int
+
+const (
+	keepWaiting verdict = iota
+	unblock
+	skip
+)
+
+// judge decides on a waiting task from the states of the tasks it depends on, found by their
+// spec IDs, telling why when the task is to be skipped
+func judge(task *storage.Task, bySpec map[string]*storage.Task, onUpstreamFailure string) (verdict, string) {
+	completed := true
+	for _, id := range task.DependsOn {
+		dep, ok := bySpec[id]
+		if !ok {
+			return skip, fmt.Sprintf("upstream task [%s] does not exist", id)
+		}
+		switch dep.State {
+		case storage.TaskFinished:
+		case storage.TaskFailed, storage.TaskSkipped, storage.TaskCancelled:
+			if onUpstreamFailure != ContinueDownstream {
+				return skip, fmt.Sprintf("upstream task [%s] %s", id, strings.ToLower(dep.State.String()))
+			}
+		default:
+			completed = false
+		}
+	}
+	if completed {
+		return unblock, ""
+	}
+	return keepWaiting, ""
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"strings"
+	"testing"
+)
+
+func TestCheckDependencies(t *testing.T) {
+	cases := []struct {
+		name      string
+		dependsOn map[string][]string
+		err       string
+	}{
+		{"diamond", map[string][]string{"a": nil, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}}, ""},
+		{"unknown task", map[string][]string{"a": nil, "b": {"z"}}, "unknown task [z]"},
+		{"itself", map[string][]string{"a": {"a"}}, "[a] -> [a]"},
+		{"cycle", map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}, "d": nil}, "[a] -> [c] -> [b] -> [a]"},
+	}
+	for _, c := range cases {
+		err := CheckDependencies(c.dependsOn)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error = %v, want one containing %q", c.name, err, c.err)
+		}
+	}
+}
+
+func dependent(state storage.TaskState, specID string, dependsOn ...string) *storage.Task {
+	return &storage.Task{State: state, SpecID: specID, DependsOn: dependsOn}
+}
+
+func TestJudge(t *testing.T) {
+	bySpec := map[string]*storage.Task{
+		"done":    dependent(storage.TaskFinished, "done"),
+		"running": dependent(storage.TaskRunning, "running"),
+		"failed":  dependent(storage.TaskFailed, "failed"),
+		"skipped": dependent(storage.TaskSkipped, "skipped"),
+	}
+	cases := []struct {
+		name      string
+		dependsOn []string
+		policy    string
+		verdict   verdict
+	}{
+		{"upstream finished", []string{"done"}, SkipDownstream, unblock},
+		{"upstream running", []string{"done", "running"}, SkipDownstream, keepWaiting},
+		{"upstream failed", []string{"running", "failed"}, SkipDownstream, skip},
+		{"upstream skipped", []string{"skipped"}, SkipDownstream, skip},
+		{"upstream failed on failing job", []string{"failed"}, FailJob, skip},
+		{"upstream failed on continue", []string{"done", "failed", "skipped"}, ContinueDownstream, unblock},
+		{"upstream running on continue", []string{"failed", "running"}, ContinueDownstream, keepWaiting},
+	}
+	for _, c := range cases {
+		v, reason := judge(dependent(storage.TaskWaiting, "task", c.dependsOn...), bySpec, c.policy)
+		if v != c.verdict {
+			t.Errorf("%s: verdict = %d, want %d", c.name, v, c.verdict)
+		}
+		if (v == skip) != (reason != "") {
+			t.Errorf("%s: unexpected reason %q", c.name, reason)
+		}
+	}
+}
+
+func TestGetJobState(t *testing.T) {
+	tasks := func(states ...storage.TaskState) []*storage.Task {
+		var tasks []*storage.Task
+		for _, state := range states {
+			tasks = append(tasks, &storage.Task{State: state})
+		}
+		return tasks
+	}
+	cases := []struct {
+		name   string
+		job    storage.Job
+		result storage.JobState
+	}{
+		{"waiting", storage.Job{Tasks: tasks(storage.TaskPending, storage.TaskWaiting)}, storage.JobQueued},
+		{"running", storage.Job{Tasks: tasks(storage.TaskFinished, storage.TaskWaiting)}, storage.JobRunning},
+		{"downstream skipped", storage.Job{Tasks: tasks(storage.TaskFinished, storage.TaskFailed, storage.TaskSkipped)}, storage.JobFinished},
+		{"none finished", storage.Job{Tasks: tasks(storage.TaskFailed, storage.TaskSkipped)}, storage.JobFailed},
+		{"failing job", storage.Job{OnUpstreamFailure: FailJob, Tasks: tasks(storage.TaskFinished, storage.TaskFailed, storage.TaskSkipped)}, storage.JobFailed},
+		{"failing job finished", storage.Job{OnUpstreamFailure: FailJob, Tasks: tasks(storage.TaskFinished, storage.TaskFinished)}, storage.JobFinished},
+	}
+	s := &Supervisor{}
+	for _, c := range cases {
+		if state := s.getJobState(c.job); state != c.result {
+			t.Errorf("%s: state = %s, want %s", c.name, state, c.result)
+		}
+	}
+}
@@ -1,11 +1,33 @@
 package service
 
 import (
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
 	"log"
+	"strings"
 	"sync"
 )
 
+var (
+	JobCompletedErr  = errors.Conflict.New("the job has already completed")
+	NoSupervisorErr  = errors.Conflict.New("the queue has no supervisor")
+	QueueDrainingErr = errors.Conflict.New("the queue is draining, it accepts no new jobs")
+	QueueActiveErr   = errors.Conflict.New("the queue has jobs not completed, force the deletion to cancel them")
+	DefaultQueueErr  = errors.Conflict.New("the default queue can not be deleted")
+	UnknownStateErr  = errors.BadRequest.New("unknown queue state, use one of open, paused or draining")
+)
+
+// ParseQueueState reads the name of a queue state, e.g. paused, in any case
+func ParseQueueState(name string) (storage.QueueState, error) {
+	for _, state := range []storage.QueueState{storage.QueueOpen, storage.QueuePaused, storage.QueueDraining} {
+		if strings.EqualFold(name, state.String()) {
+			return state, nil
+		}
+	}
+	return 0, UnknownStateErr
+}
+
 type Dispatcher struct {
 	jobsAccepted chan *storage.Job
 	supervisors  map[uint]*Supervisor
@@ -33,28 +55,188 @@ func (d *Dispatcher) HireSupervisor(queue *storage.Queue) *Supervisor {
 
 func (d *Dispatcher) Start() {
 	log.Println("Arrebol Dispatcher start accept jobs")
-	d.initDefaultSupervisor()
+	d.recover()
 
 	for job := range d.jobsAccepted {
 		// only receive jobs that belong to a queue
-		super := d.supervisors[job.QueueID]
+		d.mux.Lock()
+		super, ok := d.supervisors[job.QueueID]
+		d.mux.Unlock()
+		if !ok {
+			log.Printf("Job %d belongs to queue %d, which has no supervisor", job.ID, job.QueueID)
+			continue
+		}
 		super.Collect(job)
 	}
 }
 
-func (d *Dispatcher) initDefaultSupervisor() {
-	var q *storage.Queue
+func (d *Dispatcher) AcceptJob(job *storage.Job) {
+	log.Printf("Job %d accepted\n", job.ID)
+	job.State = storage.JobQueued
+	d.jobsAccepted <- job
+}
+
+// Requeue hands a task that went back to pending to the scheduler of its queue.
+func (d *Dispatcher) Requeue(task *storage.Task) {
+	job, err := storage.DB.RetrieveJob(task.JobID)
+	if err != nil {
+		log.Printf("Unable to requeue task [%d]: %s", task.ID, err.Error())
+		return
+	}
 
-	q, err := storage.DB.GetDefaultQueue()
+	d.mux.Lock()
+	super, ok := d.supervisors[job.QueueID]
+	d.mux.Unlock()
 
-	if err == nil && q != nil {
-		super := d.HireSupervisor(q)
-		go super.Start()
+	if !ok {
+		log.Printf("Unable to requeue task [%d]: queue [%d] has no supervisor", task.ID, job.QueueID)
+		return
 	}
+	log.Printf("Requeueing task [%d] on queue [%d]", task.ID, job.QueueID)
+	events.TaskChanged(task)
+	super.scheduler.AddTask(task, job.Priority)
 }
 
-func (d *Dispatcher) AcceptJob(job *storage.Job) {
-	log.Printf("Job %d accepted\n", job.ID)
-	job.State = storage.JobQueued
-	d.jobsAccepted <- job
+// CancelJob cancels a job that has not completed yet, aborting its running tasks
+func (d *Dispatcher) CancelJob(job *storage.Job) error {
+	if job.State.IsCompleted() {
+		return JobCompletedErr
+	}
+	if err := storage.DB.CancelJob(job.ID); err != nil {
+		return err
+	}
+	events.JobChanged(job.ID, storage.JobCancelled)
+
+	d.mux.Lock()
+	super, ok := d.supervisors[job.QueueID]
+	d.mux.Unlock()
+
+	if ok {
+		super.Cancel(job.ID)
+	}
+	log.Printf("Job %d cancelled", job.ID)
+	return nil
+}
+
+// UpdateQueue renames a queue and changes its scheduling policy and state at once. A new policy
+// reorders the tasks already waiting. A paused queue runs none of its tasks, and a draining one
+// accepts no new jobs.
+func (d *Dispatcher) UpdateQueue(queueID uint, update storage.QueueUpdate) error {
+	var policy SchedulingPolicy
+	if update.Policy != nil {
+		var err error
+		if policy, err = NewPolicy(*update.Policy); err != nil {
+			return err
+		}
+	}
+
+	d.mux.Lock()
+	super, ok := d.supervisors[queueID]
+	d.mux.Unlock()
+
+	if !ok {
+		return NoSupervisorErr
+	}
+	if err := storage.DB.UpdateQueue(queueID, update); err != nil {
+		return err
+	}
+	if policy != nil {
+		super.scheduler.SetPolicy(policy)
+		log.Printf("Queue %d now schedules by %s", queueID, *update.Policy)
+	}
+	if update.State != nil {
+		super.SetState(*update.State)
+		log.Printf("Queue %d is now %s", queueID, *update.State)
+	}
+	return nil
+}
+
+// DeleteQueue deletes a queue and fires its supervisor. A queue with jobs not completed is
+// only deleted when forced, which cancels them.
+func (d *Dispatcher) DeleteQueue(queue *storage.Queue, force bool) error {
+	if queue.ID == storage.DefaultQueueID {
+		return DefaultQueueErr
+	}
+	var active []*storage.Job
+	for _, job := range queue.Jobs {
+		if !job.State.IsCompleted() {
+			active = append(active, job)
+		}
+	}
+	if len(active) > 0 && !force {
+		return QueueActiveErr
+	}
+	for _, job := range active {
+		if err := d.CancelJob(job); err != nil && err != JobCompletedErr {
+			return err
+		}
+	}
+	if err := storage.DB.DeleteQueue(queue.ID); err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	super, ok := d.supervisors[queue.ID]
+	delete(d.supervisors, queue.ID)
+	d.mux.Unlock()
+
+	if ok {
+		super.Fire()
+	}
+	log.Printf("Queue %d deleted", queue.ID)
+	return nil
+}
+
+// SetJobPriority changes the priority of a job, reordering its tasks still waiting for a worker
+func (d *Dispatcher) SetJobPriority(job *storage.Job, priority int) error {
+	if job.State.IsCompleted() {
+		return JobCompletedErr
+	}
+	if err := storage.DB.SetJobPriority(job.ID, priority); err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	super, ok := d.supervisors[job.QueueID]
+	d.mux.Unlock()
+
+	if ok {
+		super.scheduler.Reprioritize(job.ID, priority)
+	}
+	log.Printf("Job %d now has priority %d", job.ID, priority)
+	return nil
+}
+
+// AddNode hands a node registered to a queue to its scheduler, which hires the workers of the node
+func (d *Dispatcher) AddNode(node *storage.ResourceNode) error {
+	d.mux.Lock()
+	super, ok := d.supervisors[node.QueueID]
+	d.mux.Unlock()
+
+	if !ok {
+		return NoSupervisorErr
+	}
+	return super.scheduler.AddNode(node)
+}
+
+// DrainNode stops a node from taking new tasks. It is removed once the tasks it runs end.
+func (d *Dispatcher) DrainNode(node *storage.ResourceNode) error {
+	drained, err := storage.DB.DrainNode(node.ID)
+	if err != nil {
+		return err
+	}
+	if !drained {
+		return NodeDrainingErr
+	}
+	node.State = storage.Draining
+
+	d.mux.Lock()
+	super, ok := d.supervisors[node.QueueID]
+	d.mux.Unlock()
+
+	// a queue without a supervisor removes its draining nodes once it gets one
+	if ok {
+		super.scheduler.DrainNode(node.ID)
+	}
+	return nil
 }
@@ -0,0 +1,280 @@
+package driver
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-units"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/docker"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The task config keys read by the docker driver. Keys with the docker prefix
+// other than these are rejected.
+const (
+	DockerPropertyPrefix = "docker_"
+	// DockerMemoryPropertyKey limits the memory of the container, e.g. "512m" or "2g"
+	DockerMemoryPropertyKey = "docker_memory"
+	// DockerCPUSharesPropertyKey is the relative CPU weight of the container
+	DockerCPUSharesPropertyKey = "docker_cpu_shares"
+	// DockerCPUQuotaPropertyKey and DockerCPUPeriodPropertyKey limit the CPU time of the
+	// container, in microseconds of each period
+	DockerCPUQuotaPropertyKey  = "docker_cpu_quota"
+	DockerCPUPeriodPropertyKey = "docker_cpu_period"
+	// DockerEnvPropertyPrefix prefixes the environment variables, e.g. "docker_env.PATH"
+	DockerEnvPropertyPrefix  = "docker_env."
+	DockerWorkdirPropertyKey = "docker_workdir"
+	DockerUserPropertyKey    = "docker_user"
+	// DockerNetworkPropertyKey is the network mode, e.g. "bridge", "host" or "none"
+	DockerNetworkPropertyKey = "docker_network"
+	// DockerMountsPropertyKey is a comma-separated list of bind mounts, each one
+	// as "source:target" or "source:target:ro"
+	DockerMountsPropertyKey = "docker_mounts"
+	// DockerInputsPropertyKey is a comma-separated list of files or directories of the server
+	// copied into existing directories of the container before the commands run, each one
+	// as "source:target". The sources must be under the staging directory.
+	DockerInputsPropertyKey = "docker_inputs"
+	// DockerOutputsPropertyKey is a comma-separated list of files or directories of the
+	// container copied into directories of the server once the commands ran, each one
+	// as "source:target". The targets must be under the staging directory.
+	DockerOutputsPropertyKey = "docker_outputs"
+)
+
+// StagingPathKey is the env key of the directory of the server holding the inputs and outputs
+// of the tasks. Tasks may not transfer files when it is not set.
+const StagingPathKey = "DOCKER_STAGING_PATH"
+
+var NoStagingErr = errors.New("the server has no staging directory set for the transfers")
+
+// ValidateConfig checks the config of a task before it is accepted
+func ValidateConfig(config map[string]string) error {
+	for _, key := range []string{TimeoutPropertyKey, CommandTimeoutPropertyKey} {
+		if value, ok := config[key]; ok {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				return malformed(key, "must be a positive duration, e.g. 90s or 1h30m")
+			}
+		}
+	}
+	_, err := containerConfig("", config)
+	return err
+}
+
+// containerConfig translates the docker keys of a task config to the config of its container
+func containerConfig(name string, config map[string]string) (docker.ContainerConfig, error) {
+	c := docker.ContainerConfig{
+		Name:   name,
+		Image:  DefaultWorkerDockerImage,
+		Mounts: []mount.Mount{},
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	// the keys are sorted so the same error is reported for the same config
+	sort.Strings(keys)
+
+	var err error
+	for _, key := range keys {
+		value := config[key]
+		switch {
+		case !strings.HasPrefix(key, DockerPropertyPrefix):
+			// the key belongs to something else
+		case key == DockerImagePropertyKey:
+			if value == "" {
+				return c, malformed(key, "must not be empty")
+			}
+			c.Image = value
+		case key == DockerMemoryPropertyKey:
+			if c.Resources.Memory, err = units.RAMInBytes(value); err != nil || c.Resources.Memory <= 0 {
+				return c, malformed(key, "must be a positive size, e.g. 512m or 2g")
+			}
+		case key == DockerCPUSharesPropertyKey:
+			if c.Resources.CPUShares, err = positiveInt(value); err != nil {
+				return c, malformed(key, err.Error())
+			}
+		case key == DockerCPUQuotaPropertyKey:
+			if c.Resources.CPUQuota, err = positiveInt(value); err != nil {
+				return c, malformed(key, err.Error())
+			}
+		case key == DockerCPUPeriodPropertyKey:
+			if c.Resources.CPUPeriod, err = positiveInt(value); err != nil {
+				return c, malformed(key, err.Error())
+			}
+		case strings.HasPrefix(key, DockerEnvPropertyPrefix):
+			variable := strings.TrimPrefix(key, DockerEnvPropertyPrefix)
+			if variable == "" || strings.ContainsAny(variable, "= ") {
+				return c, malformed(key, "must name an environment variable")
+			}
+			c.Env = append(c.Env, variable+"="+value)
+		case key == DockerWorkdirPropertyKey:
+			if !path.IsAbs(value) {
+				return c, malformed(key, "must be an absolute path")
+			}
+			c.WorkingDir = value
+		case key == DockerUserPropertyKey:
+			if value == "" {
+				return c, malformed(key, "must not be empty")
+			}
+			c.User = value
+		case key == DockerNetworkPropertyKey:
+			if value == "" {
+				return c, malformed(key, "must not be empty")
+			}
+			c.NetworkMode = container.NetworkMode(value)
+		case key == DockerMountsPropertyKey:
+			if c.Mounts, err = parseMounts(value); err != nil {
+				return c, malformed(key, err.Error())
+			}
+		case key == DockerInputsPropertyKey || key == DockerOutputsPropertyKey:
+			transfers, err := parseTransfers(value)
+			if err != nil {
+				return c, malformed(key, err.Error())
+			}
+			for _, t := range transfers {
+				if _, err = staged(t.hostPath(key)); err != nil {
+					return c, malformed(key, err.Error())
+				}
+			}
+		default:
+			return c, errors.AddErrorContext(errors.BadRequest.Newf("Unknown config [%s]", key), key, "unknown")
+		}
+	}
+	return c, nil
+}
+
+func parseMounts(value string) ([]mount.Mount, error) {
+	mounts := []mount.Mount{}
+	for _, spec := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, errors.Newf("mount [%s] must be source:target or source:target:ro", spec)
+		}
+		if !path.IsAbs(parts[0]) || !path.IsAbs(parts[1]) {
+			return nil, errors.Newf("the paths of mount [%s] must be absolute", spec)
+		}
+		readOnly := false
+		if len(parts) == 3 {
+			if parts[2] != "ro" && parts[2] != "rw" {
+				return nil, errors.Newf("the mode of mount [%s] must be ro or rw", spec)
+			}
+			readOnly = parts[2] == "ro"
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   parts[0],
+			Target:   parts[1],
+			ReadOnly: readOnly,
+		})
+	}
+	return mounts, nil
+}
+
+// transfer is a file or directory copied between the server and a container, into the directory target
+type transfer struct {
+	source string
+	target string
+}
+
+func parseTransfers(value string) ([]transfer, error) {
+	var transfers []transfer
+	for _, spec := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) != 2 {
+			return nil, errors.Newf("transfer [%s] must be source:target", spec)
+		}
+		if !path.IsAbs(parts[0]) || !path.IsAbs(parts[1]) {
+			return nil, errors.Newf("the paths of transfer [%s] must be absolute", spec)
+		}
+		transfers = append(transfers, transfer{source: parts[0], target: parts[1]})
+	}
+	return transfers, nil
+}
+
+// hostPath is the path of the transfer on the server, the source of an input and the target of an output
+func (t transfer) hostPath(key string) string {
+	if key == DockerInputsPropertyKey {
+		return t.source
+	}
+	return t.target
+}
+
+// staged resolves a path of the server, following its symlinks, and fails when it is not
+// under the staging directory. A path that does not exist yet is resolved up to its
+// deepest existing parent.
+func staged(p string) (string, error) {
+	root := os.Getenv(StagingPathKey)
+	if root == "" {
+		return "", NoStagingErr
+	}
+	root, err := resolve(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := resolve(p)
+	if err != nil {
+		return "", err
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", errors.Newf("[%s] is outside of the staging directory", p)
+	}
+	return resolved, nil
+}
+
+// resolve makes the path absolute and follows the symlinks of its deepest existing part
+func resolve(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) || p == filepath.Dir(p) {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = filepath.Dir(p)
+	}
+}
+
+// taskTransfers are the transfers the config of the task lists under the key, which were
+// validated along with the task
+func taskTransfers(task *storage.Task, key string) []transfer {
+	value, ok := configOf(task)[key]
+	if !ok {
+		return nil
+	}
+	transfers, _ := parseTransfers(value)
+	return transfers
+}
+
+func positiveInt(value string) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("must be a positive integer")
+	}
+	return n, nil
+}
+
+func malformed(key, reason string) error {
+	return errors.AddErrorContext(errors.BadRequest.Newf("Malformed config [%s]: %s", key, reason), key, reason)
+}
+
+func configOf(task *storage.Task) map[string]string {
+	config := make(map[string]string, len(task.Config))
+	for _, conf := range task.Config {
+		config[conf.Key] = conf.Value
+	}
+	return config
+}
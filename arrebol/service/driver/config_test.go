@@ -0,0 +1,136 @@
+package driver
+
+import (
+	"github.com/docker/docker/api/types/mount"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestContainerConfig(t *testing.T) {
+	c, err := containerConfig("worker", map[string]string{
+		DockerImagePropertyKey:           "ubuntu",
+		DockerMemoryPropertyKey:          "1g",
+		DockerCPUSharesPropertyKey:       "512",
+		DockerEnvPropertyPrefix + "HOME": "/data",
+		DockerWorkdirPropertyKey:         "/data",
+		DockerNetworkPropertyKey:         "none",
+		DockerMountsPropertyKey:          "/srv/in:/data/in:ro, /srv/out:/data/out",
+		TimeoutPropertyKey:               "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Image != "ubuntu" || c.Resources.Memory != 1<<30 || c.Resources.CPUShares != 512 {
+		t.Errorf("unexpected image or resources: %+v", c)
+	}
+	if len(c.Env) != 1 || c.Env[0] != "HOME=/data" || c.WorkingDir != "/data" || c.NetworkMode != "none" {
+		t.Errorf("unexpected container config: %+v", c)
+	}
+	want := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/srv/in", Target: "/data/in", ReadOnly: true},
+		{Type: mount.TypeBind, Source: "/srv/out", Target: "/data/out"},
+	}
+	if len(c.Mounts) != len(want) || c.Mounts[0] != want[0] || c.Mounts[1] != want[1] {
+		t.Errorf("mounts = %+v, want %+v", c.Mounts, want)
+	}
+}
+
+func TestContainerConfigDefaultImage(t *testing.T) {
+	c, err := containerConfig("worker", map[string]string{})
+	if err != nil || c.Image != DefaultWorkerDockerImage {
+		t.Errorf("got %q, %v; want the default image", c.Image, err)
+	}
+}
+
+func TestValidateConfigRejects(t *testing.T) {
+	configs := []map[string]string{
+		{"docker_memroy": "1g"},
+		{DockerMemoryPropertyKey: "a lot"},
+		{DockerCPUQuotaPropertyKey: "-1"},
+		{DockerEnvPropertyPrefix: "value"},
+		{DockerWorkdirPropertyKey: "relative/path"},
+		{DockerMountsPropertyKey: "/srv:/data:rx"},
+		{DockerMountsPropertyKey: "/srv"},
+		{DockerInputsPropertyKey: "/srv/in:relative"},
+		{DockerOutputsPropertyKey: "/data/out:/srv/out:ro"},
+		{TimeoutPropertyKey: "forever"},
+	}
+	for _, config := range configs {
+		if err := ValidateConfig(config); err == nil {
+			t.Errorf("ValidateConfig(%v) should fail", config)
+		}
+	}
+}
+
+func TestTaskTransfers(t *testing.T) {
+	task := &storage.Task{Config: []storage.TaskConfig{
+		{Key: DockerInputsPropertyKey, Value: "/srv/in:/data, /srv/model.bin:/data"},
+	}}
+	want := []transfer{{source: "/srv/in", target: "/data"}, {source: "/srv/model.bin", target: "/data"}}
+	if got := taskTransfers(task, DockerInputsPropertyKey); !reflect.DeepEqual(got, want) {
+		t.Errorf("inputs = %+v, want %+v", got, want)
+	}
+	if got := taskTransfers(task, DockerOutputsPropertyKey); got != nil {
+		t.Errorf("outputs = %+v, want none", got)
+	}
+}
+
+func TestStaged(t *testing.T) {
+	defer os.Unsetenv(StagingPathKey)
+	root, err := ioutil.TempDir("", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err = os.Mkdir(root+"/in", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("/etc", root+"/in/etc"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(root+"/in", root+"/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = staged(root + "/in/model.bin"); err != NoStagingErr {
+		t.Errorf("a path was staged without a staging directory: %v", err)
+	}
+	os.Setenv(StagingPathKey, root)
+	// the root may be a symlink itself, e.g. /tmp on some systems
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+	valid := map[string]string{
+		root + "/in/model.bin":      resolvedRoot + "/in/model.bin",
+		root + "/out/7/results":     resolvedRoot + "/out/7/results",
+		root + "/link/model.bin":    resolvedRoot + "/in/model.bin",
+		root + "/in/../in/data.csv": resolvedRoot + "/in/data.csv",
+	}
+	for p, want := range valid {
+		if got, err := staged(p); err != nil || got != want {
+			t.Errorf("staged(%q) = %q, %v, want %q", p, got, err, want)
+		}
+	}
+	for _, p := range []string{"/etc/cron.d", root + "/../passwd", root + "/in/etc/passwd", root + "/in/etc/cron.d/job"} {
+		if got, err := staged(p); err == nil {
+			t.Errorf("staged(%q) = %q, want it rejected", p, got)
+		}
+	}
+
+	config := map[string]string{DockerInputsPropertyKey: root + "/in/model.bin:/data", DockerOutputsPropertyKey: "/data/out:" + root + "/out"}
+	if err = ValidateConfig(config); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, config := range []map[string]string{
+		{DockerInputsPropertyKey: "/root/.ssh/id_rsa:/data"},
+		{DockerOutputsPropertyKey: "/data/out:/etc/cron.d"},
+		{DockerOutputsPropertyKey: "/data/out:" + root + "/in/etc"},
+	} {
+		if err = ValidateConfig(config); err == nil {
+			t.Errorf("ValidateConfig(%v) should fail", config)
+		}
+	}
+}
@@ -1,26 +1,39 @@
 package driver
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
-	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
 	"github.com/ufcg-lsd/arrebol-pb/docker"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"io/ioutil"
 	"log"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	TaskScriptExecutorFileName  = "task-script-executor.sh"
-	TaskScriptExecutorPath      = "./resources/" + TaskScriptExecutorFileName
-	RunTaskScriptCommandPattern = "/bin/bash %s -d -tsf=%s"
-	PoolingPeriodTime           = 2 * time.Second
-	DockerImagePropertyKey      = "docker_image"
-	DefaultWorkerDockerImage    = "wesleymonte/simple-worker"
+	TaskScriptExecutorFileName = "task-script-executor.sh"
+	TaskScriptExecutorPath     = "./resources/" + TaskScriptExecutorFileName
+	TaskScriptFileName         = "task.ts"
+	// TasksDir is where the files of the tasks are kept inside the containers, each task in
+	// a directory named after its ID
+	TasksDir                 = "/tmp/arrebol"
+	DockerImagePropertyKey   = "docker_image"
+	DefaultWorkerDockerImage = "wesleymonte/simple-worker"
+)
+
+// The lines the executor writes to report the progress of the commands
+const (
+	StatusStartPrefix = "ARREBOL START "
+	StatusEndPrefix   = "ARREBOL END "
+	StatusDone        = "ARREBOL DONE"
 )
 
 const (
@@ -31,162 +44,312 @@ const (
 	StopContainerErrorMsg         string = "Error while stopping container [%s]"
 	RemoveContainerErrorMsg       string = "Error while removing container [%s]"
 	SendTaskScriptFileErrorMsg    string = "Error while send task script file [%s]"
+	UploadInputErrorMsg           string = "Error while uploading the input [%s] to [%s]"
+	DownloadOutputErrorMsg        string = "Error while downloading the output [%s] to [%s]"
 	RunTaskScriptExecutorErrorMsg string = "Error while running the " + TaskScriptExecutorFileName
-	GettingExitCodesErrorMsg      string = "Error while getting content of exit codes file [%s]"
-	TrackTaskErrorMsg             string = "Error while track task execution [%s]"
+	ExecutorCrashedErrorMsg       string = "The " + TaskScriptExecutorFileName + " of task [%d] exited with code [%d] before running the commands"
+	MalformedStatusErrorMsg       string = "Malformed status [%s] reported by the " + TaskScriptExecutorFileName
 )
 
+// DockerDriver runs each task in a container of its own, named and labeled after the task
 type DockerDriver struct {
-	Id  string
 	Cli client.Client
 }
 
-func (d *DockerDriver) Execute(task *storage.Task) error {
-	image, err := task.GetConfig(DockerImagePropertyKey)
-	if err == nil {
-		image = DefaultWorkerDockerImage
-	}
-	config := docker.ContainerConfig{
-		Name:   d.Id,
-		Image:  image,
-		Mounts: []mount.Mount{},
-	}
-	if err = d.initiate(config); err != nil {
+// ContainerName is the name of the container of the task
+func ContainerName(task *storage.Task) string {
+	return fmt.Sprintf("arrebol-job-%d-task-%d", task.JobID, task.ID)
+}
+
+func (d *DockerDriver) Execute(ctx context.Context, task *storage.Task) error {
+	config, err := containerConfig(ContainerName(task), configOf(task))
+	if err != nil {
 		return err
 	}
-	if err = d.send(task); err != nil {
-		return err
+	config.Env = append(config.Env, TaskEnv(task)...)
+	config.Labels = map[string]string{
+		docker.ManagedLabel: "true",
+		docker.JobLabel:     strconv.Itoa(int(task.JobID)),
+		docker.TaskLabel:    strconv.Itoa(int(task.ID)),
 	}
-	if err = d.run(strconv.Itoa(int(task.ID))); err != nil {
-		return err
+	if err = d.initiate(ctx, config); err == nil {
+		if err = d.send(ctx, task); err == nil {
+			if err = d.run(ctx, task); err == nil {
+				err = d.collect(ctx, task)
+			}
+		}
 	}
-	if err = d.track(task); err != nil {
+	// the steps above fail once the context is done, so the container is cleaned up regardless
+	if ctx.Err() != nil {
+		d.interrupt(ctx, task)
+		return d.stop(context.Background(), config.Name)
+	}
+	if err != nil {
+		_ = d.stop(context.Background(), config.Name)
 		return err
 	}
-	if err = d.stop(); err != nil {
+	if err = d.stop(ctx, config.Name); err != nil {
 		return err
 	}
-	task.State = storage.TaskFinished
+	completed(ctx, task)
 	return nil
 }
 
-func (d *DockerDriver) initiate(config docker.ContainerConfig) error {
-	exist, err := docker.CheckImage(&d.Cli, config.Image)
+// interrupt records the end of a task whose context is done
+func (d *DockerDriver) interrupt(ctx context.Context, task *storage.Task) {
+	for _, cmd := range task.Commands {
+		if cmd.State == storage.CmdRunning {
+			interrupted(ctx, task, cmd)
+		} else {
+			cancel(task, cmd)
+		}
+	}
+	completed(ctx, task)
+}
+
+func (d *DockerDriver) initiate(ctx context.Context, config docker.ContainerConfig) error {
+	exist, err := docker.CheckImage(ctx, &d.Cli, config.Image)
 	if !exist {
-		if _, err = docker.Pull(&d.Cli, config.Image); err != nil {
+		if _, err = docker.Pull(ctx, &d.Cli, config.Image); err != nil {
 			return errors.Wrapf(err, PullImageErrorMsg, config.Image)
 		}
 	}
-	cid, err := docker.CreateContainer(&d.Cli, config)
+	// a container left behind by an earlier attempt of the task would take the name
+	_ = docker.RemoveContainer(ctx, &d.Cli, config.Name)
+	cid, err := docker.CreateContainer(ctx, &d.Cli, config)
 	if err != nil {
 		return errors.Wrapf(err, CreateContainerErrorMsg, config.Name)
 	}
-	err = docker.StartContainer(&d.Cli, cid)
+	err = docker.StartContainer(ctx, &d.Cli, cid)
 	if err != nil {
 		return errors.Wrapf(err, StartContainerErrorMsg, config.Name)
 	}
-	err = docker.Copy(&d.Cli, cid, TaskScriptExecutorPath, "/tmp/"+TaskScriptExecutorFileName)
-	if err != nil {
-		return errors.Wrapf(err, CopyTaskScriptErrorMsg, d.Id, TaskScriptExecutorFileName)
-	}
-	return err
+	return nil
 }
 
-func (d *DockerDriver) stop() error {
-	err := docker.StopContainer(&d.Cli, d.Id)
+func (d *DockerDriver) stop(ctx context.Context, name string) error {
+	err := docker.StopContainer(ctx, &d.Cli, name)
 	if err != nil {
-		return errors.Wrapf(err, StopContainerErrorMsg, d.Id)
+		return errors.Wrapf(err, StopContainerErrorMsg, name)
 	}
-	err = docker.RemoveContainer(&d.Cli, d.Id)
+	err = docker.RemoveContainer(ctx, &d.Cli, name)
 	if err != nil {
-		return errors.Wrapf(err, RemoveContainerErrorMsg, d.Id)
+		return errors.Wrapf(err, RemoveContainerErrorMsg, name)
 	}
 	return err
 }
 
-func (d *DockerDriver) send(task *storage.Task) error {
-	taskScriptFileName := "task-id.ts"
-	rawCmdsStr := task.GetRawCommands()
-	err := docker.Write(&d.Cli, d.Id, rawCmdsStr, "/tmp/"+taskScriptFileName)
-	if err != nil {
-		err = errors.Wrapf(err, SendTaskScriptFileErrorMsg, taskScriptFileName)
+// taskScript is the task script file read by the executor, with each command encoded in
+// base64 on a line of its own, so that a command spanning many lines keeps its index
+func taskScript(commands []string) []byte {
+	var script bytes.Buffer
+	for _, cmd := range commands {
+		script.WriteString(base64.StdEncoding.EncodeToString([]byte(cmd)))
+		script.WriteByte('\n')
 	}
-	return err
+	return script.Bytes()
 }
 
-func (d *DockerDriver) run(taskId string) error {
-	taskScriptFilePath := "/tmp/task-id.ts"
-	cmd := fmt.Sprintf(RunTaskScriptCommandPattern, "/tmp/"+TaskScriptExecutorFileName, taskScriptFilePath)
-	err := docker.Exec(&d.Cli, d.Id, cmd)
+// send writes the executor and the commands of the task to the directory of the task, then
+// copies the inputs of the task into the container
+func (d *DockerDriver) send(ctx context.Context, task *storage.Task) error {
+	executor, err := ioutil.ReadFile(TaskScriptExecutorPath)
 	if err != nil {
-		err = errors.Wrap(err, RunTaskScriptExecutorErrorMsg)
+		return errors.Wrapf(err, CopyTaskScriptErrorMsg, ContainerName(task), TaskScriptExecutorFileName)
 	}
-	return err
+	script := taskScript(task.GetRawCommands())
+
+	dir := path.Base(TasksDir) + "/" + strconv.Itoa(int(task.ID))
+	err = docker.WriteFiles(ctx, &d.Cli, ContainerName(task), path.Dir(TasksDir),
+		docker.File{Name: dir + "/" + TaskScriptExecutorFileName, Content: executor, Mode: 0755},
+		docker.File{Name: dir + "/" + TaskScriptFileName, Content: script, Mode: 0644})
+	if err != nil {
+		return errors.Wrapf(err, SendTaskScriptFileErrorMsg, TaskScriptFileName)
+	}
+
+	for _, in := range taskTransfers(task, DockerInputsPropertyKey) {
+		// the staging directory is checked again, since its symlinks may have changed since
+		source, err := staged(in.source)
+		if err == nil {
+			err = docker.Upload(ctx, &d.Cli, ContainerName(task), source, in.target)
+		}
+		if err != nil {
+			return errors.Wrapf(err, UploadInputErrorMsg, in.source, in.target)
+		}
+	}
+	return nil
 }
 
-func (d *DockerDriver) track(task *storage.Task) error {
-	i := 0
-	for i < len(task.Commands) {
-		ec, err := d.getExitCodes("task-id")
+// collect copies the outputs of the task out of the container, once its commands ran
+func (d *DockerDriver) collect(ctx context.Context, task *storage.Task) error {
+	for _, out := range taskTransfers(task, DockerOutputsPropertyKey) {
+		target, err := staged(out.target)
+		if err == nil {
+			err = docker.Download(ctx, &d.Cli, ContainerName(task), out.source, target)
+		}
 		if err != nil {
-			return errors.Wrapf(err, TrackTaskErrorMsg, "task-id")
+			return errors.Wrapf(err, DownloadOutputErrorMsg, out.source, out.target)
 		}
-		i = d.syncCommands(task.Commands, ec, i)
-		time.Sleep(PoolingPeriodTime)
 	}
 	return nil
 }
 
-func (d *DockerDriver) getExitCodes(taskId string) ([]int8, error) {
-	ecFilePath := "/tmp/task-id" + ".ts.ec"
-	dat, err := docker.Cat(&d.Cli, d.Id, ecFilePath)
+// run executes the commands of the task, following the progress reported by the executor.
+// A command running for longer than the command timeout times out the task.
+func (d *DockerDriver) run(ctx context.Context, task *storage.Task) error {
+	dir := taskDir(task)
+	stderr := &lineWriter{publish: func(line string) {
+		log.Printf("The %s of task [%d] says: %s", TaskScriptExecutorFileName, task.ID, line)
+	}}
+	cmd := []string{"/bin/bash", dir + "/" + TaskScriptExecutorFileName, "-tsf=" + dir + "/" + TaskScriptFileName}
+	execution, err := docker.ExecAttached(ctx, &d.Cli, ContainerName(task), cmd, stderr)
 	if err != nil {
-		err = errors.Wrapf(err, GettingExitCodesErrorMsg, ecFilePath)
-		return nil, err
-	}
-	dat = bytes.TrimFunc(dat, isNotUTFNumber)
-	content := string(dat[:])
-	log.Println("Content: " + content)
-	exitCodesStr := strings.Split(content, "\r\n")
-	log.Println("ExitCodes String Array: ", exitCodesStr)
-	exitCodes := toIntArray(exitCodesStr)
-	log.Println(exitCodes)
-	return exitCodes, nil
-}
-
-func (d *DockerDriver) syncCommands(commands []*storage.Command, exitCodes []int8, startIndex int) int {
-	i := startIndex
-	for ; i < len(exitCodes); i++ {
-		ec := exitCodes[i]
-		if ec == 0 {
-			commands[i].State = storage.CmdFinished
-		} else {
-			commands[i].State = storage.CmdFailed
+		return errors.Wrap(err, RunTaskScriptExecutorErrorMsg)
+	}
+	defer execution.Close()
+
+	statuses := make(chan string)
+	go func() {
+		defer close(statuses)
+		scanner := bufio.NewScanner(execution.Stdout)
+		for scanner.Scan() {
+			select {
+			case statuses <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	timeout := CommandTimeout(task)
+	var running *storage.Command
+	var deadline <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			running.State = storage.CmdTimedOut
+			running.ExitCode = TimeoutExitCode
+			saveCommand(task, running)
+			cancelRemaining(task)
+			return nil
+		case status, ok := <-statuses:
+			if !ok {
+				return d.exited(ctx, task, execution, running)
+			}
+			if status == StatusDone {
+				return nil
+			}
+			index, code, err := parseStatus(status, len(task.Commands))
+			if err != nil {
+				return err
+			}
+			cmd := task.Commands[index]
+			if code == nil {
+				cmd.State = storage.CmdRunning
+				saveCommand(task, cmd)
+				running = cmd
+				if timeout > 0 {
+					deadline = time.After(timeout)
+				}
+				continue
+			}
+			d.finish(ctx, task, index, *code)
+			running, deadline = nil, nil
 		}
-		commands[i].ExitCode = ec
-		storage.DB.SaveCommand(commands[i])
 	}
-	if i < len(commands) {
-		commands[i].State = storage.CmdRunning
-		storage.DB.SaveCommand(commands[i])
+}
+
+// exited handles the executor ending before reporting that every command was executed.
+// The command it was running, if any, is taken as the one that ended it, e.g. with exit.
+func (d *DockerDriver) exited(ctx context.Context, task *storage.Task, execution *docker.AttachedExec, running *storage.Command) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	code, err := execution.ExitCode(ctx, &d.Cli)
+	if err != nil {
+		return errors.Wrap(err, RunTaskScriptExecutorErrorMsg)
 	}
-	return i
+	if running == nil {
+		return errors.Newf(ExecutorCrashedErrorMsg, task.ID, code)
+	}
+	for i, cmd := range task.Commands {
+		if cmd == running {
+			d.finish(ctx, task, i, code)
+		}
+	}
+	cancelRemaining(task)
+	return nil
 }
 
-func toIntArray(strs []string) []int8 {
-	ints := make([]int8, 0)
-	for _, s := range strs {
-		x, err := strconv.Atoi(s)
-		if err == nil {
-			ints = append(ints, int8(x))
+// finish records the end of the index-th command of the task
+func (d *DockerDriver) finish(ctx context.Context, task *storage.Task, index, code int) {
+	cmd := task.Commands[index]
+	if code == SuccessExitCode {
+		cmd.State = storage.CmdFinished
+	} else {
+		cmd.State = storage.CmdFailed
+	}
+	cmd.ExitCode = code
+	d.collectLogs(ctx, task, cmd, index)
+	saveCommand(task, cmd)
+}
+
+// collectLogs keeps the output of the index-th command, written by the executor inside the container
+func (d *DockerDriver) collectLogs(ctx context.Context, task *storage.Task, cmd *storage.Command, index int) {
+	files := map[string]string{Stdout: "out", Stderr: "err"}
+	for stream, ext := range files {
+		path := fmt.Sprintf("%s/%d.%s", taskDir(task), index, ext)
+		w := newLogWriter(task, cmd, stream)
+		if err := docker.ReadFile(ctx, &d.Cli, ContainerName(task), path, w); err != nil {
+			log.Printf("Unable to collect the %s of command [%d]: %s", stream, cmd.ID, err.Error())
+		}
+		_ = w.Close()
+	}
+}
+
+// parseStatus parses a line reported by the executor, either the start of a command or its
+// end, along with its exit code.
+func parseStatus(status string, commands int) (int, *int, error) {
+	var fields []string
+	switch {
+	case strings.HasPrefix(status, StatusStartPrefix):
+		fields = strings.Fields(strings.TrimPrefix(status, StatusStartPrefix))
+		if len(fields) != 1 {
+			return 0, nil, errors.Newf(MalformedStatusErrorMsg, status)
 		}
+	case strings.HasPrefix(status, StatusEndPrefix):
+		fields = strings.Fields(strings.TrimPrefix(status, StatusEndPrefix))
+		if len(fields) != 2 {
+			return 0, nil, errors.Newf(MalformedStatusErrorMsg, status)
+		}
+	default:
+		return 0, nil, errors.Newf(MalformedStatusErrorMsg, status)
+	}
+
+	index, err := strconv.Atoi(fields[0])
+	if err != nil || index < 0 || index >= commands {
+		return 0, nil, errors.Newf(MalformedStatusErrorMsg, status)
+	}
+	if len(fields) == 1 {
+		return index, nil, nil
 	}
-	return ints
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, nil, errors.Newf(MalformedStatusErrorMsg, status)
+	}
+	return index, &code, nil
 }
 
-func isNotUTFNumber(r rune) bool {
-	if r >= 48 && r <= 57 {
-		return false
+// cancelRemaining cancels the commands of the task that did not run
+func cancelRemaining(task *storage.Task) {
+	for _, cmd := range task.Commands {
+		cancel(task, cmd)
 	}
-	return true
+}
+
+func taskDir(task *storage.Task) string {
+	return TasksDir + "/" + strconv.Itoa(int(task.ID))
 }
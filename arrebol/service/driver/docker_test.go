@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	index, code, err := parseStatus("ARREBOL START 1", 3)
+	if err != nil || index != 1 || code != nil {
+		t.Errorf("got %d, %v, %v; want the start of the command 1", index, code, err)
+	}
+
+	index, code, err = parseStatus("ARREBOL END 2 137", 3)
+	if err != nil || index != 2 || code == nil || *code != 137 {
+		t.Errorf("got %d, %v, %v; want the end of the command 2 with code 137", index, code, err)
+	}
+}
+
+func TestParseStatusRejects(t *testing.T) {
+	for _, status := range []string{"ARREBOL START 3", "ARREBOL END 0", "ARREBOL END 0 x", "ARREBOL START -1", "hello"} {
+		if _, _, err := parseStatus(status, 3); err == nil {
+			t.Errorf("parseStatus(%q) should fail", status)
+		}
+	}
+}
+
+func TestTaskScriptRunsMultilineCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	commands := []string{"echo one", "cat <<EOF\ntwo\nthree\nEOF", "(exit 3)", "printf '%s' \"$HOME\" | wc -c"}
+	script := dir + "/" + TaskScriptFileName
+	if err = ioutil.WriteFile(script, taskScript(commands), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("/bin/bash", "../../../resources/"+TaskScriptExecutorFileName, "-tsf="+script).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ARREBOL START 0\nARREBOL END 0 0\nARREBOL START 1\nARREBOL END 1 0\n" +
+		"ARREBOL START 2\nARREBOL END 2 3\nARREBOL START 3\nARREBOL END 3 0\nARREBOL DONE\n"
+	if string(out) != want {
+		t.Errorf("status = %q, want %q", out, want)
+	}
+	if content, _ := ioutil.ReadFile(dir + "/1.out"); string(content) != "two\nthree\n" {
+		t.Errorf("the output of the multiline command is %q", content)
+	}
+}
@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"github.com/ufcg-lsd/arrebol-pb/storage/blob"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+
+	LogMaxSizeKey     = "LOG_MAX_SIZE"
+	DefaultLogMaxSize = 1 << 20 // bytes kept of each stream of a command
+)
+
+// LogKey is the blob key of an output stream of a command
+func LogKey(taskID, cmdID uint, stream string) string {
+	return fmt.Sprintf("tasks/%d/commands/%d/%s", taskID, cmdID, stream)
+}
+
+// newLogWriter opens the blob that keeps an output stream of the command, publishing
+// each line written as well. When there is no blob store, or it fails, the output is
+// only published rather than failing the command.
+func newLogWriter(task *storage.Task, cmd *storage.Command, stream string) io.WriteCloser {
+	lines := &lineWriter{publish: func(line string) {
+		events.LogLine(task.JobID, cmd, stream, line)
+	}}
+	if blob.DefaultStore == nil {
+		return lines
+	}
+	w, err := blob.DefaultStore.Create(LogKey(cmd.TaskID, cmd.ID, stream))
+	if err != nil {
+		log.Printf("Unable to keep the %s of command [%d]: %s", stream, cmd.ID, err.Error())
+		return lines
+	}
+	return &teeWriter{&cappedWriter{w: w, left: logMaxSize()}, lines}
+}
+
+func logMaxSize() int64 {
+	if size, err := strconv.ParseInt(os.Getenv(LogMaxSizeKey), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return DefaultLogMaxSize
+}
+
+// cappedWriter writes up to a limit, silently dropping the rest. A note is appended
+// on close if anything was dropped.
+type cappedWriter struct {
+	w         io.WriteCloser
+	left      int64
+	truncated bool
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+		c.truncated = true
+	}
+	if len(p) > 0 {
+		written, err := c.w.Write(p)
+		c.left -= int64(written)
+		if err != nil {
+			return written, err
+		}
+	}
+	// the dropped bytes are reported as written so the command is not disturbed
+	return n, nil
+}
+
+func (c *cappedWriter) Close() error {
+	if c.truncated {
+		_, _ = io.WriteString(c.w, "\n[arrebol: output truncated]\n")
+	}
+	return c.w.Close()
+}
+
+// lineWriter hands each complete line written to publish, the last one on close
+type lineWriter struct {
+	publish func(line string)
+	partial []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	data := append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		l.publish(string(bytes.TrimSuffix(data[:i], []byte("\r"))))
+		data = data[i+1:]
+	}
+	if len(data) > events.MaxLineSize {
+		l.publish(string(data))
+		data = nil
+	}
+	l.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (l *lineWriter) Close() error {
+	if len(l.partial) > 0 {
+		l.publish(string(l.partial))
+		l.partial = nil
+	}
+	return nil
+}
+
+// teeWriter writes to both of its writers, reporting the errors of the first one
+type teeWriter struct {
+	first  io.WriteCloser
+	second io.WriteCloser
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	_, _ = t.second.Write(p)
+	return t.first.Write(p)
+}
+
+func (t *teeWriter) Close() error {
+	_ = t.second.Close()
+	return t.first.Close()
+}
@@ -0,0 +1,43 @@
+package driver
+
+import (
+	"bytes"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error {
+	return nil
+}
+
+func TestCappedWriter(t *testing.T) {
+	buf := &bufferCloser{}
+	w := &cappedWriter{w: buf, left: 5}
+
+	if n, err := w.Write([]byte("abc")); n != 3 || err != nil {
+		t.Errorf("Write() = %d, %v; want 3, nil", n, err)
+	}
+	if n, err := w.Write([]byte("defg")); n != 4 || err != nil {
+		t.Errorf("Write() = %d, %v; want 4, nil", n, err)
+	}
+	_ = w.Close()
+
+	if want := "abcde\n[arrebol: output truncated]\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestCappedWriterUnderLimit(t *testing.T) {
+	buf := &bufferCloser{}
+	w := &cappedWriter{w: buf, left: 5}
+
+	_, _ = w.Write([]byte("abcde"))
+	_ = w.Close()
+
+	if buf.String() != "abcde" {
+		t.Errorf("got %q, want %q", buf.String(), "abcde")
+	}
+}
@@ -1,57 +1,121 @@
 package driver
 
 import (
+	"context"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
 	"log"
-	"os/exec"
-	"strings"
+	"strconv"
+	"time"
 )
 
 const (
 	FailExitCode    = 1
 	SuccessExitCode = 0
+	NoExitCode      = -1
 )
 
+// ArrayIndexEnvKey is the environment variable telling the commands of a task expanded
+// from an array the index of the task
+const ArrayIndexEnvKey = "ARREBOL_ARRAY_INDEX"
+
 type Driver interface {
-	Execute(t *storage.Task) error
+	// Execute runs the commands of the task until they end or the context is done.
+	// A cancelled context aborts the task, while an expired deadline times it out.
+	Execute(ctx context.Context, t *storage.Task) error
 }
 
+// RawDriver runs the commands of a task on the host, in a bash session of its own
 type RawDriver struct{}
 
-func (r *RawDriver) Execute(task *storage.Task) error {
-	flawed := false
-	for _, cmd := range task.Commands {
-		r.execute(cmd)
-		if cmd.State == storage.CmdFailed {
-			flawed = true
+func (r *RawDriver) Execute(ctx context.Context, task *storage.Task) error {
+	cmdTimeout := CommandTimeout(task)
+	interrupt := false
+
+	var sess *session
+	defer func() {
+		if sess != nil {
+			sess.close()
 		}
-	}
+	}()
 
-	if flawed {
-		task.State = storage.TaskFailed
-	} else {
-		task.State = storage.TaskFinished
+	for i, cmd := range task.Commands {
+		// once a command is interrupted the remaining ones are not run
+		if interrupt || ctx.Err() != nil {
+			cancel(task, cmd)
+			continue
+		}
+		// a command may end the shell, the following ones run in a new one
+		if sess == nil || sess.closed {
+			var err error
+			if sess, err = startSession(TaskEnv(task)...); err != nil {
+				for _, c := range task.Commands[i:] {
+					cancel(task, c)
+				}
+				return err
+			}
+		}
+		interrupt = r.execute(ctx, task, sess, cmd, cmdTimeout)
 	}
+
+	completed(ctx, task)
 	return nil
 }
 
-func (r *RawDriver) execute(cmd *storage.Command) {
+// execute runs a command, telling if it was interrupted by a cancellation or a timeout
+func (r *RawDriver) execute(ctx context.Context, task *storage.Task, sess *session, cmd *storage.Command, timeout time.Duration) bool {
 	cmd.State = storage.CmdRunning
-	_ = storage.DB.SaveCommand(cmd)
-	cmdStr := cmd.RawCommand
-	parts := strings.Fields(cmdStr)
-	head := parts[0]
-	parts = parts[1:]
-	out, err := exec.Command(head, parts...).Output()
+	saveCommand(task, cmd)
+
+	if timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, timeout)
+		defer stop()
+	}
+
+	stdout, stderr := newLogWriter(task, cmd, Stdout), newLogWriter(task, cmd, Stderr)
+	code, err := sess.run(ctx, cmd.RawCommand, stdout, stderr)
+	_ = stdout.Close()
+	_ = stderr.Close()
 
+	if interrupted(ctx, task, cmd) {
+		return true
+	}
 	if err != nil {
-		log.Printf("%s", err)
+		log.Printf("Command [%d] could not be run: %s", cmd.ID, err)
+		cmd.State = storage.CmdFailed
+		cmd.ExitCode = NoExitCode
+	} else if code != SuccessExitCode {
 		cmd.State = storage.CmdFailed
-		cmd.ExitCode = FailExitCode
+		cmd.ExitCode = code
 	} else {
-		log.Printf("%s", out)
 		cmd.State = storage.CmdFinished
 		cmd.ExitCode = SuccessExitCode
 	}
+	saveCommand(task, cmd)
+	return false
+}
+
+// TaskEnv is the environment the drivers set for the commands of the task
+func TaskEnv(task *storage.Task) []string {
+	if task.ArrayIndex == nil {
+		return nil
+	}
+	return []string{ArrayIndexEnvKey + "=" + strconv.Itoa(*task.ArrayIndex)}
+}
+
+// cancel marks a command as cancelled, unless it has already completed
+func cancel(task *storage.Task, cmd *storage.Command) {
+	if cmd.State != storage.CmdNotStarted && cmd.State != storage.CmdRunning {
+		return
+	}
+	cmd.State = storage.CmdCancelled
+	cmd.ExitCode = NoExitCode
+	saveCommand(task, cmd)
+}
+
+// saveCommand persists the command of the task and publishes its state
+func saveCommand(task *storage.Task, cmd *storage.Command) {
 	_ = storage.DB.SaveCommand(cmd)
+	events.CommandChanged(task.JobID, cmd)
 }
@@ -0,0 +1,239 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var SessionClosedErr = errors.New("the shell session is closed")
+
+// session is a bash process that runs the commands of a task one after the other, so the
+// working directory and the variables set by a command are seen by the following ones.
+// The output of each command is told apart from the next by a marker the shell prints
+// after it, while its exit status is written to a third pipe.
+type session struct {
+	proc   *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *demux
+	stderr *demux
+	status *bufio.Reader
+	pipe   *os.File
+	id     string
+	count  int
+	closed bool
+}
+
+// startSession starts the shell with the environment of the server along with the given variables
+func startSession(env ...string) (*session, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	proc := exec.Command("bash", "--noprofile", "--norc")
+	proc.Env = append(os.Environ(), env...)
+	// the commands may leave processes behind, they are killed along with the shell
+	proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdin, err := proc.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := proc.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := proc.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	proc.ExtraFiles = []*os.File{statusW}
+
+	err = proc.Start()
+	_ = statusW.Close()
+	if err != nil {
+		_ = statusR.Close()
+		return nil, err
+	}
+
+	return &session{
+		proc:   proc,
+		stdin:  stdin,
+		stdout: newDemux(stdout),
+		stderr: newDemux(stderr),
+		status: bufio.NewReader(statusR),
+		pipe:   statusR,
+		id:     id,
+	}, nil
+}
+
+// run executes the command line, copying its output to stdout and stderr, and returns
+// its exit status. When the context is done the session is killed and its error returned.
+// A command that ends the shell, e.g. with exit, closes the session.
+func (s *session) run(ctx context.Context, line string, stdout, stderr io.Writer) (int, error) {
+	if s.closed {
+		return NoExitCode, SessionClosedErr
+	}
+
+	// a malformed line would make the shell wait for the rest of it
+	if code, ok := checkSyntax(ctx, line, stderr); !ok {
+		return code, ctx.Err()
+	}
+
+	s.count++
+	marker := fmt.Sprintf("__arrebol_%s_%d__", s.id, s.count)
+	script := fmt.Sprintf("{ %s\n} </dev/null 3>&-\n__arrebol_ec=$?\n"+
+		"printf %%s %s; printf %%s %s >&2; echo $__arrebol_ec >&3\n", line, marker, marker)
+
+	done := make(chan int, 1)
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			_ = s.stdout.copyUntil([]byte(marker), stdout)
+			wg.Done()
+		}()
+		go func() {
+			_ = s.stderr.copyUntil([]byte(marker), stderr)
+			wg.Done()
+		}()
+
+		code, err := s.readStatus()
+		if err != nil {
+			// the shell is gone, what is left of its output is read before waiting for it
+			s.kill()
+			wg.Wait()
+			code = exitStatus(s.wait())
+		}
+		wg.Wait()
+		done <- code
+	}()
+
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		s.kill()
+		<-done
+		return NoExitCode, err
+	}
+
+	select {
+	case code := <-done:
+		return code, nil
+	case <-ctx.Done():
+		s.kill()
+		<-done
+		return NoExitCode, ctx.Err()
+	}
+}
+
+func (s *session) readStatus() (int, error) {
+	line, err := s.status.ReadString('\n')
+	if err != nil {
+		return NoExitCode, err
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return NoExitCode, err
+	}
+	return code, nil
+}
+
+// close ends the shell, along with whatever the commands left running
+func (s *session) close() {
+	if s.closed {
+		return
+	}
+	_ = s.stdin.Close()
+	s.kill()
+	_ = s.wait()
+}
+
+func (s *session) wait() error {
+	err := s.proc.Wait()
+	_ = s.pipe.Close()
+	s.closed = true
+	return err
+}
+
+func (s *session) kill() {
+	_ = syscall.Kill(-s.proc.Process.Pid, syscall.SIGKILL)
+}
+
+// checkSyntax tells if the command line is well formed, writing the complaints of the shell to stderr
+func checkSyntax(ctx context.Context, line string, stderr io.Writer) (int, bool) {
+	check := exec.CommandContext(ctx, "bash", "-n", "-c", line)
+	check.Stderr = stderr
+	if err := check.Run(); err != nil {
+		return exitStatus(err), false
+	}
+	return SuccessExitCode, true
+}
+
+// exitStatus is the status of a finished process as reported by the shell, i.e. 128
+// plus the signal number for processes killed by a signal
+func exitStatus(err error) int {
+	if err == nil {
+		return SuccessExitCode
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				return 128 + int(ws.Signal())
+			}
+			return ws.ExitStatus()
+		}
+	}
+	return FailExitCode
+}
+
+// demux splits a stream at the markers written to it
+type demux struct {
+	r       io.Reader
+	buf     []byte
+	pending []byte
+	err     error
+}
+
+func newDemux(r io.Reader) *demux {
+	return &demux{r: r, buf: make([]byte, 32*1024)}
+}
+
+// copyUntil copies to w what is read up to the marker, keeping what comes after it.
+// If the stream ends before the marker, everything read is copied and the error returned.
+func (d *demux) copyUntil(marker []byte, w io.Writer) error {
+	for {
+		if i := bytes.Index(d.pending, marker); i >= 0 {
+			_, _ = w.Write(d.pending[:i])
+			d.pending = d.pending[i+len(marker):]
+			return nil
+		}
+		if d.err != nil {
+			_, _ = w.Write(d.pending)
+			d.pending = nil
+			return d.err
+		}
+		// the end of what was read may be the beginning of the marker
+		if keep := len(marker) - 1; len(d.pending) > keep {
+			n := len(d.pending) - keep
+			_, _ = w.Write(d.pending[:n])
+			d.pending = append(d.pending[:0], d.pending[n:]...)
+		}
+		n, err := d.r.Read(d.buf)
+		d.pending = append(d.pending, d.buf[:n]...)
+		d.err = err
+	}
+}
@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCarriesStateAcrossCommands(t *testing.T) {
+	sess, err := startSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.close()
+
+	lines := []string{"cd /tmp", "export GREETING='hello world'", "echo \"$GREETING from $(pwd)\" | tr a-z A-Z"}
+	var stdout bytes.Buffer
+	for _, line := range lines {
+		stdout.Reset()
+		if code, err := sess.run(context.Background(), line, &stdout, &bytes.Buffer{}); code != 0 || err != nil {
+			t.Fatalf("run(%q) = %d, %v", line, code, err)
+		}
+	}
+	if want := "HELLO WORLD FROM /TMP\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestSessionSetsTaskEnv(t *testing.T) {
+	index := 7
+	sess, err := startSession(TaskEnv(&storage.Task{ArrayIndex: &index})...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.close()
+
+	var stdout bytes.Buffer
+	if code, err := sess.run(context.Background(), "echo $"+ArrayIndexEnvKey, &stdout, &bytes.Buffer{}); code != 0 || err != nil {
+		t.Fatalf("run = %d, %v", code, err)
+	}
+	if stdout.String() != "7\n" {
+		t.Errorf("stdout = %q, want the array index", stdout.String())
+	}
+}
+
+func TestSessionExitCodes(t *testing.T) {
+	sess, err := startSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.close()
+
+	cases := map[string]int{
+		"true":                  0,
+		"(exit 255)":            255,
+		"bash -c 'kill -9 $$'":  137,
+		"echo 'unterminated":    2,
+		"printf no-newline >&2": 0,
+	}
+	for line, want := range cases {
+		var stderr bytes.Buffer
+		code, err := sess.run(context.Background(), line, &bytes.Buffer{}, &stderr)
+		if err != nil || code != want {
+			t.Errorf("run(%q) = %d, %v; want %d", line, code, err, want)
+		}
+		if strings.Contains(stderr.String(), "__arrebol_") {
+			t.Errorf("run(%q) leaked the marker: %q", line, stderr.String())
+		}
+	}
+}
+
+func TestSessionEndedByCommand(t *testing.T) {
+	sess, err := startSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.close()
+
+	var stdout bytes.Buffer
+	code, err := sess.run(context.Background(), "echo bye; exit 3", &stdout, &bytes.Buffer{})
+	if err != nil || code != 3 {
+		t.Errorf("run = %d, %v; want 3", code, err)
+	}
+	if stdout.String() != "bye\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "bye\n")
+	}
+	if !sess.closed {
+		t.Error("the session should be closed")
+	}
+}
+
+func TestSessionInterrupted(t *testing.T) {
+	sess, err := startSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = sess.run(ctx, "sleep 10", &bytes.Buffer{}, &bytes.Buffer{}); err != context.DeadlineExceeded {
+		t.Errorf("run = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDemuxSplitsAtMarkers(t *testing.T) {
+	d := newDemux(strings.NewReader("first__M1__second__M2__rest"))
+	// markers split across reads are found as well
+	d.buf = make([]byte, 3)
+
+	cases := []struct{ marker, want string }{{"__M1__", "first"}, {"__M2__", "second"}}
+	for _, c := range cases {
+		var out bytes.Buffer
+		if err := d.copyUntil([]byte(c.marker), &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("got %q, want %q", out.String(), c.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := d.copyUntil([]byte("__M3__"), &out); err == nil || out.String() != "rest" {
+		t.Errorf("got %q, %v; want \"rest\" and the end of the stream", out.String(), err)
+	}
+}
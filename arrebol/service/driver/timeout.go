@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"context"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"log"
+	"time"
+)
+
+const (
+	// TimeoutPropertyKey bounds the wall-clock time of the whole task, e.g. "1h30m"
+	TimeoutPropertyKey = "timeout"
+	// CommandTimeoutPropertyKey bounds the wall-clock time of each command of the task
+	CommandTimeoutPropertyKey = "command_timeout"
+	// TimeoutExitCode is recorded for commands killed by a timeout, as GNU timeout does
+	TimeoutExitCode = 124
+)
+
+// TaskContext derives the context of a task execution, bounded by the task timeout when one is declared
+func TaskContext(parent context.Context, task *storage.Task) (context.Context, context.CancelFunc) {
+	if timeout := durationConfig(task, TimeoutPropertyKey); timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// CommandTimeout is the timeout of each command of the task, zero when not declared
+func CommandTimeout(task *storage.Task) time.Duration {
+	return durationConfig(task, CommandTimeoutPropertyKey)
+}
+
+func durationConfig(task *storage.Task, key string) time.Duration {
+	value, err := task.GetConfig(key)
+	if err != nil {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("Ignoring malformed config [%s] of task [%d]: %s", key, task.ID, value)
+		return 0
+	}
+	return d
+}
+
+// interrupted records how a command interrupted by the context ended. It tells
+// if the command was in fact interrupted, i.e. cancelled or timed out.
+func interrupted(ctx context.Context, task *storage.Task, cmd *storage.Command) bool {
+	switch ctx.Err() {
+	case context.Canceled:
+		cmd.State = storage.CmdCancelled
+		cmd.ExitCode = NoExitCode
+	case context.DeadlineExceeded:
+		cmd.State = storage.CmdTimedOut
+		cmd.ExitCode = TimeoutExitCode
+	default:
+		return false
+	}
+	saveCommand(task, cmd)
+	return true
+}
+
+// completed sets the final state of a task. A cancelled execution ends cancelled,
+// while a timed out one ends failed.
+func completed(ctx context.Context, task *storage.Task) {
+	flawed := false
+	for _, cmd := range task.Commands {
+		if cmd.State == storage.CmdFailed || cmd.State == storage.CmdTimedOut {
+			flawed = true
+		}
+	}
+
+	if ctx.Err() == context.Canceled {
+		task.State = storage.TaskCancelled
+	} else if flawed || ctx.Err() == context.DeadlineExceeded {
+		task.State = storage.TaskFailed
+	} else {
+		task.State = storage.TaskFinished
+	}
+}
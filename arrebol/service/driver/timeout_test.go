@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"testing"
+	"time"
+)
+
+func timedTask(config map[string]string, states ...storage.CommandState) *storage.Task {
+	task := &storage.Task{}
+	for key, value := range config {
+		task.Config = append(task.Config, storage.TaskConfig{Key: key, Value: value})
+	}
+	for _, state := range states {
+		task.Commands = append(task.Commands, &storage.Command{State: state})
+	}
+	return task
+}
+
+func TestTaskTimesOut(t *testing.T) {
+	task := timedTask(map[string]string{TimeoutPropertyKey: "20ms"}, storage.CmdFinished, storage.CmdRunning)
+	ctx, cancel := TaskContext(context.Background(), task)
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatal("the context of a task with a timeout has no deadline")
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the task did not time out")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want the deadline exceeded", ctx.Err())
+	}
+
+	// the command left running is the one the timeout interrupted
+	task.Commands[1].State = storage.CmdTimedOut
+	task.Commands[1].ExitCode = TimeoutExitCode
+	completed(ctx, task)
+	if task.State != storage.TaskFailed {
+		t.Errorf("a timed out task ended %s, want failed", task.State)
+	}
+}
+
+func TestTaskWithoutTimeout(t *testing.T) {
+	for _, config := range []map[string]string{nil, {TimeoutPropertyKey: "forever"}} {
+		task := timedTask(config, storage.CmdFinished)
+		ctx, cancel := TaskContext(context.Background(), task)
+		if _, ok := ctx.Deadline(); ok {
+			t.Errorf("%v: the context has a deadline", config)
+		}
+
+		completed(ctx, task)
+		if task.State != storage.TaskFinished {
+			t.Errorf("%v: task ended %s, want finished", config, task.State)
+		}
+		cancel()
+		completed(ctx, task)
+		if task.State != storage.TaskCancelled {
+			t.Errorf("%v: a cancelled task ended %s, want cancelled", config, task.State)
+		}
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	if d := CommandTimeout(timedTask(map[string]string{CommandTimeoutPropertyKey: "1m30s"})); d != 90*time.Second {
+		t.Errorf("command timeout = %s, want 1m30s", d)
+	}
+	if d := CommandTimeout(timedTask(map[string]string{CommandTimeoutPropertyKey: "-1s"})); d != 0 {
+		t.Errorf("a negative command timeout was taken as %s", d)
+	}
+}
@@ -3,6 +3,7 @@ package errors
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"strings"
 )
 
 // ErrorType is the type of an error
@@ -15,6 +16,10 @@ const (
 	BadRequest
 	// NotFound error
 	NotFound
+	// Conflict error
+	Conflict
+	// Unprocessable error
+	Unprocessable
 )
 
 type customError struct {
@@ -100,7 +105,7 @@ func AddErrorContext(err error, field, message string) error {
 // GetErrorContext returns the error context
 func GetErrorContext(err error) map[string]string {
 	emptyContext := errorContext{}
-	if customErr, ok := err.(customError); ok || customErr.context != emptyContext {
+	if customErr, ok := err.(customError); ok && customErr.context != emptyContext {
 
 		return map[string]string{"field": customErr.context.Field, "message": customErr.context.Message}
 	}
@@ -113,6 +118,51 @@ func GetType(err error) ErrorType {
 	if customErr, ok := err.(customError); ok {
 		return customErr.errorType
 	}
+	if aggregate, ok := err.(*aggregateError); ok {
+		return aggregate.errorType
+	}
 
 	return NoType
 }
+
+type aggregateError struct {
+	errorType ErrorType
+	message   string
+	errs      []error
+}
+
+// Aggregate gathers the errors found at once, e.g. while validating a request, into an error of the type
+func (errorType ErrorType) Aggregate(msg string, errs ...error) error {
+	return &aggregateError{errorType: errorType, message: msg, errs: errs}
+}
+
+// Error returns the message of the aggregate followed by the ones of its errors
+func (error *aggregateError) Error() string {
+	messages := make([]string, 0, len(error.errs))
+	for _, err := range error.errs {
+		messages = append(messages, err.Error())
+	}
+	return error.message + ": " + strings.Join(messages, "; ")
+}
+
+// GetErrorContexts returns the contexts of the errors gathered by an aggregate, or the one
+// of the error itself
+func GetErrorContexts(err error) []map[string]string {
+	aggregate, ok := err.(*aggregateError)
+	if !ok {
+		if context := GetErrorContext(err); context != nil {
+			return []map[string]string{context}
+		}
+		return nil
+	}
+
+	var contexts []map[string]string
+	for _, e := range aggregate.errs {
+		context := GetErrorContext(e)
+		if context == nil {
+			context = map[string]string{"field": "", "message": e.Error()}
+		}
+		contexts = append(contexts, context)
+	}
+	return contexts
+}
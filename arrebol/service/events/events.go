@@ -0,0 +1,246 @@
+// Package events publishes the state transitions of jobs, tasks and commands, as well
+// as the output lines of the commands, to whoever is following a job.
+package events
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	JobKind     Kind = "job"
+	TaskKind    Kind = "task"
+	CommandKind Kind = "command"
+	LogKind     Kind = "log"
+)
+
+const (
+	// DefaultHistorySize is how many of the last state events are kept to be replayed
+	DefaultHistorySize = 4096
+	// DefaultLogHistorySize is how many of the last log lines are kept to be replayed, apart
+	// from the state events so that a chatty command does not push them out
+	DefaultLogHistorySize = 1024
+	// SubscriptionBufferSize is how many events a subscriber may fall behind before being dropped
+	SubscriptionBufferSize = 256
+	// MaxLineSize bounds the size of the log lines carried by the events
+	MaxLineSize = 4096
+)
+
+// DefaultBus is the bus every component publishes to
+var DefaultBus = NewBus(DefaultHistorySize, DefaultLogHistorySize)
+
+type Event struct {
+	ID        uint64    `json:"ID"`
+	Kind      Kind      `json:"Kind"`
+	JobID     uint      `json:"JobID"`
+	TaskID    uint      `json:"TaskID,omitempty"`
+	CommandID uint      `json:"CommandID,omitempty"`
+	State     string    `json:"State,omitempty"`
+	ExitCode  *int      `json:"ExitCode,omitempty"`
+	Stream    string    `json:"Stream,omitempty"`
+	Line      string    `json:"Line,omitempty"`
+	Time      time.Time `json:"Time"`
+}
+
+// Bus delivers the events to the subscribers of their jobs. The last events are kept
+// in rings, one for the log lines and one for the rest, so subscribers can resume from
+// the last event they have seen.
+type Bus struct {
+	seq     uint64
+	history *ring
+	logs    *ring
+	subs    map[*Subscription]bool
+	mux     sync.Mutex
+}
+
+func NewBus(historySize, logHistorySize int) *Bus {
+	return &Bus{
+		history: newRing(historySize),
+		logs:    newRing(logHistorySize),
+		subs:    make(map[*Subscription]bool),
+	}
+}
+
+// ring keeps the last events added to it, overwriting the oldest one once it is full
+type ring struct {
+	events []Event
+	next   int
+}
+
+func newRing(size int) *ring {
+	return &ring{events: make([]Event, 0, size)}
+}
+
+func (r *ring) add(e Event) {
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, e)
+	} else if cap(r.events) > 0 {
+		r.events[r.next] = e
+		r.next = (r.next + 1) % cap(r.events)
+	}
+}
+
+// since lists the kept events the subscription wants published after lastID, oldest first
+func (r *ring) since(sub *Subscription, lastID uint64) []Event {
+	var events []Event
+	n := len(r.events)
+	for i := 0; i < n; i++ {
+		e := r.events[(r.next+i)%n]
+		if sub.wants(e) && e.ID > lastID {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Subscription receives the events of a job. C is closed when the subscription
+// is cancelled or when the subscriber falls too far behind.
+type Subscription struct {
+	C     chan Event
+	jobID uint
+	// kinds are the kinds of the events received, all of them when empty
+	kinds map[Kind]bool
+}
+
+func (sub *Subscription) wants(e Event) bool {
+	return sub.jobID == e.JobID && (len(sub.kinds) == 0 || sub.kinds[e.Kind])
+}
+
+// Publish assigns the next ID to the event and delivers it
+func (b *Bus) Publish(e Event) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.seq++
+	e.ID = b.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if e.Kind == LogKind {
+		b.logs.add(e)
+	} else {
+		b.history.add(e)
+	}
+
+	for sub := range b.subs {
+		if !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			// a slow subscriber must not hold the publishers, it may resume from its last event
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe follows the events of a job, returning the kept events published after
+// lastID along with the subscription to the upcoming ones.
+func (b *Bus) Subscribe(jobID uint, lastID uint64) ([]Event, *Subscription) {
+	return b.SubscribeKinds(jobID, lastID)
+}
+
+// SubscribeKinds follows only the events of the kinds, e.g. leaving out the output lines
+func (b *Bus) SubscribeKinds(jobID uint, lastID uint64, kinds ...Kind) ([]Event, *Subscription) {
+	sub := &Subscription{
+		C:     make(chan Event, SubscriptionBufferSize),
+		jobID: jobID,
+		kinds: make(map[Kind]bool),
+	}
+	for _, kind := range kinds {
+		sub.kinds[kind] = true
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	// IDs restart along with the server, so an ID from the future replays everything
+	if lastID > b.seq {
+		lastID = 0
+	}
+
+	backlog := merge(b.history.since(sub, lastID), b.logs.since(sub, lastID))
+	b.subs[sub] = true
+	return backlog, sub
+}
+
+// merge merges two lists of events ordered by ID into one
+func merge(a, b []Event) []Event {
+	if len(b) == 0 {
+		return a
+	}
+	events := make([]Event, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].ID < b[0].ID {
+			events, a = append(events, a[0]), a[1:]
+		} else {
+			events, b = append(events, b[0]), b[1:]
+		}
+	}
+	return append(append(events, a...), b...)
+}
+
+// Unsubscribe cancels the subscription, it is safe to call more than once
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.drop(sub)
+}
+
+func (b *Bus) drop(sub *Subscription) {
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
+
+func JobChanged(jobID uint, state storage.JobState) {
+	DefaultBus.Publish(Event{
+		Kind:  JobKind,
+		JobID: jobID,
+		State: state.String(),
+	})
+}
+
+func TaskChanged(task *storage.Task) {
+	DefaultBus.Publish(Event{
+		Kind:   TaskKind,
+		JobID:  task.JobID,
+		TaskID: task.ID,
+		State:  task.State.String(),
+	})
+}
+
+func CommandChanged(jobID uint, cmd *storage.Command) {
+	e := Event{
+		Kind:      CommandKind,
+		JobID:     jobID,
+		TaskID:    cmd.TaskID,
+		CommandID: cmd.ID,
+		State:     cmd.State.String(),
+	}
+	if cmd.State != storage.CmdNotStarted && cmd.State != storage.CmdRunning {
+		ec := cmd.ExitCode
+		e.ExitCode = &ec
+	}
+	DefaultBus.Publish(e)
+}
+
+func LogLine(jobID uint, cmd *storage.Command, stream, line string) {
+	if len(line) > MaxLineSize {
+		line = line[:MaxLineSize]
+	}
+	DefaultBus.Publish(Event{
+		Kind:      LogKind,
+		JobID:     jobID,
+		TaskID:    cmd.TaskID,
+		CommandID: cmd.ID,
+		Stream:    stream,
+		Line:      line,
+	})
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubscribeReplaysAfterLastID(t *testing.T) {
+	bus := NewBus(10, 10)
+	bus.Publish(Event{Kind: JobKind, JobID: 1})
+	bus.Publish(Event{Kind: JobKind, JobID: 2})
+	bus.Publish(Event{Kind: TaskKind, JobID: 1})
+
+	backlog, sub := bus.Subscribe(1, 1)
+	defer bus.Unsubscribe(sub)
+
+	if len(backlog) != 1 || backlog[0].ID != 3 {
+		t.Fatalf("backlog = %v, want only the event 3", backlog)
+	}
+
+	bus.Publish(Event{Kind: TaskKind, JobID: 2})
+	bus.Publish(Event{Kind: TaskKind, JobID: 1})
+	if e := <-sub.C; e.ID != 5 {
+		t.Errorf("received event %d, want 5", e.ID)
+	}
+}
+
+func TestHistoryKeepsTheLastEvents(t *testing.T) {
+	bus := NewBus(3, 3)
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Kind: LogKind, JobID: 1})
+	}
+
+	backlog, sub := bus.Subscribe(1, 0)
+	defer bus.Unsubscribe(sub)
+
+	if len(backlog) != 3 {
+		t.Fatalf("got %d events, want 3", len(backlog))
+	}
+	for i, e := range backlog {
+		if e.ID != uint64(i+3) {
+			t.Errorf("backlog[%d] = %d, want %d", i, e.ID, i+3)
+		}
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	bus := NewBus(0, 0)
+	_, sub := bus.Subscribe(1, 0)
+
+	for i := 0; i <= SubscriptionBufferSize; i++ {
+		bus.Publish(Event{Kind: LogKind, JobID: 1})
+	}
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != SubscriptionBufferSize {
+		t.Errorf("received %d events, want %d", n, SubscriptionBufferSize)
+	}
+	bus.Unsubscribe(sub)
+}
+
+func TestSubscribeKinds(t *testing.T) {
+	bus := NewBus(10, 10)
+	bus.Publish(Event{Kind: LogKind, JobID: 1})
+	bus.Publish(Event{Kind: TaskKind, JobID: 1})
+
+	backlog, sub := bus.SubscribeKinds(1, 0, TaskKind, JobKind)
+	defer bus.Unsubscribe(sub)
+
+	if len(backlog) != 1 || backlog[0].Kind != TaskKind {
+		t.Fatalf("backlog = %v, want only the task event", backlog)
+	}
+
+	// the output lines do not count against the buffer of the subscriber
+	for i := 0; i <= SubscriptionBufferSize; i++ {
+		bus.Publish(Event{Kind: LogKind, JobID: 1})
+	}
+	bus.Publish(Event{Kind: JobKind, JobID: 1})
+	if e, ok := <-sub.C; !ok || e.Kind != JobKind {
+		t.Errorf("received %v, want the job event", e)
+	}
+}
+
+func TestLogLinesKeepStateEventsInHistory(t *testing.T) {
+	bus := NewBus(3, 2)
+	bus.Publish(Event{Kind: TaskKind, JobID: 1})
+	bus.Publish(Event{Kind: LogKind, JobID: 1})
+	for i := 0; i < 10; i++ {
+		bus.Publish(Event{Kind: LogKind, JobID: 1})
+	}
+	bus.Publish(Event{Kind: JobKind, JobID: 1})
+
+	backlog, sub := bus.Subscribe(1, 0)
+	defer bus.Unsubscribe(sub)
+	var ids []uint64
+	for _, e := range backlog {
+		ids = append(ids, e.ID)
+	}
+	// the state events outlive the log lines, which keep only the last ones, in order
+	if want := []uint64{1, 11, 12, 13}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("backlog = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/docker"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"os"
+	"strconv"
+)
+
+// The drivers a node may run its tasks with
+const (
+	RawNodeDriver    = "raw"
+	DockerNodeDriver = "docker"
+)
+
+// The env keys describing the default node of a queue, registered to the queue once it is created
+const (
+	DriverKey        = "DRIVER"
+	WorkerAddressKey = "WORKER_ADDRESS"
+	WorkersAmountKey = "WORKERS_AMOUNT"
+)
+
+var NodeDrainingErr = errors.Conflict.New("the node is already draining")
+
+// ValidNodeDriver tells if a node may run its tasks with the driver
+func ValidNodeDriver(name string) bool {
+	return name == RawNodeDriver || name == DockerNodeDriver
+}
+
+// DefaultNode is the node of the queue described by the env, nil when the env describes no workers.
+// The capacity set by the env is the one of each worker.
+func DefaultNode(queueID uint) *storage.ResourceNode {
+	workers, _ := strconv.Atoi(os.Getenv(WorkersAmountKey))
+	if workers <= 0 {
+		return nil
+	}
+	node := &storage.ResourceNode{
+		QueueID: queueID,
+		State:   storage.Idle,
+		Driver:  RawNodeDriver,
+		Workers: workers,
+		Labels:  localLabels(),
+	}
+	if os.Getenv(DriverKey) == DockerNodeDriver {
+		node.Driver = DockerNodeDriver
+		node.Address = os.Getenv(WorkerAddressKey)
+		node.Slots, _ = strconv.Atoi(os.Getenv(DockerWorkerSlotsKey))
+	} else {
+		node.Slots, _ = strconv.Atoi(os.Getenv(WorkerSlotsKey))
+	}
+	if node.Slots < 1 {
+		node.Slots = 1
+	}
+	capacity := localCapacity()
+	node.CPU = capacity.CPU * float64(workers)
+	node.Memory = capacity.Memory * int64(workers)
+	node.Disk = capacity.Disk * int64(workers)
+	return node
+}
+
+// SeedNode registers the default node to a queue that was just created, or that never had a
+// node. Nothing is registered when the env describes no workers.
+func SeedNode(queueID uint) error {
+	node := DefaultNode(queueID)
+	if node == nil {
+		return nil
+	}
+	return storage.DB.SaveNode(node)
+}
+
+// NodeCapacity is the capacity of the node, as declared when it was registered
+func NodeCapacity(node *storage.ResourceNode) Resources {
+	return Resources{CPU: node.CPU, Memory: node.Memory, Disk: node.Disk}
+}
+
+// nodeWorkers creates the workers of the node, each one with an even share of its capacity
+func nodeWorkers(node *storage.ResourceNode) ([]*Worker, error) {
+	count := node.Workers
+	if count < 1 {
+		count = 1
+	}
+	capacity := NodeCapacity(node)
+	share := Resources{
+		CPU:    capacity.CPU / float64(count),
+		Memory: capacity.Memory / int64(count),
+		Disk:   capacity.Disk / int64(count),
+	}
+
+	workers := make([]*Worker, 0, count)
+	for i := 0; i < count; i++ {
+		var d driver.Driver = &driver.RawDriver{}
+		if node.Driver == DockerNodeDriver {
+			cli, err := docker.NewDockerClient(node.Address)
+			if err != nil {
+				return nil, err
+			}
+			d = &driver.DockerDriver{Cli: *cli}
+		}
+		w := NewWorker(d, node.Slots, share, node.Labels)
+		w.node = node.ID
+		workers = append(workers, w)
+	}
+	return workers, nil
+}
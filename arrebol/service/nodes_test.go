@@ -0,0 +1,76 @@
+package service
+
+import (
+	"github.com/docker/go-units"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"os"
+	"testing"
+)
+
+func TestDefaultNode(t *testing.T) {
+	defer os.Unsetenv(WorkersAmountKey)
+	defer os.Unsetenv(WorkerSlotsKey)
+	defer os.Unsetenv(WorkerMemoryKey)
+
+	if node := DefaultNode(1); node != nil {
+		t.Fatalf("the env describes no workers, got the node %+v", node)
+	}
+
+	_ = os.Setenv(WorkersAmountKey, "4")
+	_ = os.Setenv(WorkerSlotsKey, "2")
+	_ = os.Setenv(WorkerMemoryKey, "1GiB")
+	node := DefaultNode(1)
+	if node == nil || node.Driver != RawNodeDriver || node.Workers != 4 || node.Slots != 2 {
+		t.Fatalf("node = %+v, want 4 raw workers with 2 slots", node)
+	}
+	// the env sets the capacity of each worker
+	if node.Memory != 4*units.GiB {
+		t.Errorf("memory = %d, want %d", node.Memory, 4*units.GiB)
+	}
+}
+
+func TestNodeWorkers(t *testing.T) {
+	node := &storage.ResourceNode{Driver: RawNodeDriver, Workers: 2, Slots: 3, CPU: 8, Memory: 4 * units.GiB}
+	node.ID = 7
+
+	workers, err := nodeWorkers(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 2 {
+		t.Fatalf("got %d workers, want 2", len(workers))
+	}
+	want := Resources{CPU: 4, Memory: 2 * units.GiB}
+	for _, w := range workers {
+		if w.node != 7 || w.slots != 3 || w.capacity != want {
+			t.Errorf("worker on node %d with %d slots and %+v, want node 7 with 3 slots and %+v", w.node, w.slots, w.capacity, want)
+		}
+	}
+}
+
+func TestNodeWorkersMalformedAddress(t *testing.T) {
+	node := &storage.ResourceNode{Driver: DockerNodeDriver, Address: "10.0.0.2", Workers: 1}
+	if _, err := nodeWorkers(node); err == nil {
+		t.Error("expected an error for a malformed docker address")
+	}
+}
+
+func TestDrainingWorker(t *testing.T) {
+	workers, _ := nodeWorkers(&storage.ResourceNode{Workers: 1, Slots: 2})
+	w := workers[0]
+	if !w.reserve(&storage.Task{}) {
+		t.Fatal("an idle worker should have a free slot")
+	}
+
+	w.drain()
+	if w.MatchAny(&storage.Task{}) || w.reserve(&storage.Task{}) {
+		t.Error("a draining worker should not take new tasks")
+	}
+	if w.retired() {
+		t.Error("a draining worker running a task should not be retired")
+	}
+	w.release(&storage.Task{})
+	if !w.retired() {
+		t.Error("a draining worker with no tasks left should be retired")
+	}
+}
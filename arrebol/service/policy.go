@@ -0,0 +1,344 @@
+package service
+
+import (
+	"container/heap"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The names of the scheduling policies a queue may use
+const (
+	FifoPolicy       = "fifo"
+	PriorityPolicy   = "priority"
+	SJFPolicy        = "sjf"
+	RoundRobinPolicy = "round-robin"
+	DefaultPolicy    = PriorityPolicy
+)
+
+// The task config keys read by the scheduling policies
+const (
+	// PriorityPropertyKey overrides the priority of the job for one of its tasks, higher runs first
+	PriorityPropertyKey = "priority"
+	// EstimatePropertyKey is how long the task is expected to run, e.g. "10m"
+	EstimatePropertyKey = "estimate"
+)
+
+var UnknownPolicyErr = errors.BadRequest.New("unknown scheduling policy, use one of fifo, priority, sjf or round-robin")
+
+// PendingTask is a task waiting to be scheduled. Seq tells the order in which the tasks
+// arrived and is kept when a task goes back to wait.
+type PendingTask struct {
+	Task *storage.Task
+	Seq  uint64
+	// Priority is the one declared by the task, or else the one of its job
+	Priority int
+}
+
+// SchedulingPolicy decides the order in which the pending tasks of a queue are scheduled.
+// Implementations are not safe for concurrent use, the scheduler guards them.
+type SchedulingPolicy interface {
+	Name() string
+	// Push adds a task to wait for a worker
+	Push(t *PendingTask)
+	// Pop takes the next task to be scheduled, nil when none is waiting
+	Pop() *PendingTask
+	// Remove drops the waiting tasks that match
+	Remove(match func(*storage.Task) bool)
+	Len() int
+}
+
+// NewPolicy creates the scheduling policy with the name
+func NewPolicy(name string) (SchedulingPolicy, error) {
+	switch name {
+	case FifoPolicy:
+		return &fifo{}, nil
+	case PriorityPolicy:
+		return newHeapPolicy(PriorityPolicy, func(a, b *PendingTask) bool {
+			return a.Priority > b.Priority
+		}), nil
+	case SJFPolicy:
+		return newHeapPolicy(SJFPolicy, func(a, b *PendingTask) bool {
+			return taskEstimate(a.Task) < taskEstimate(b.Task)
+		}), nil
+	case RoundRobinPolicy:
+		return &roundRobin{queues: make(map[uint]*fifo)}, nil
+	default:
+		return nil, UnknownPolicyErr
+	}
+}
+
+// ValidPolicy tells if there is a scheduling policy with the name
+func ValidPolicy(name string) bool {
+	_, err := NewPolicy(name)
+	return err == nil
+}
+
+// ValidateTaskConfig checks the config of a task before it is accepted, the keys read by the
+// scheduler as well as the ones read by the drivers
+func ValidateTaskConfig(config map[string]string) error {
+	if err := validateConfigKeys(config); err != nil {
+		return err
+	}
+	if value, ok := config[PriorityPropertyKey]; ok {
+		if _, err := strconv.Atoi(value); err != nil {
+			return malformedConfig(PriorityPropertyKey, "must be an integer")
+		}
+	}
+	if value, ok := config[EstimatePropertyKey]; ok {
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return malformedConfig(EstimatePropertyKey, "must be a positive duration, e.g. 10m")
+		}
+	}
+	if err := validateRequirements(config); err != nil {
+		return err
+	}
+	if err := validateRetryPolicy(config); err != nil {
+		return err
+	}
+	return driver.ValidateConfig(config)
+}
+
+// configKeys are the task config keys read by the scheduler and the workers. The keys of the
+// docker driver, under its prefix, are checked by the driver.
+var configKeys = map[string]bool{
+	PriorityPropertyKey:              true,
+	EstimatePropertyKey:              true,
+	CPUPropertyKey:                   true,
+	MemoryPropertyKey:                true,
+	DiskPropertyKey:                  true,
+	ConstraintsPropertyKey:           true,
+	RetryAttemptsPropertyKey:         true,
+	RetryExitCodesPropertyKey:        true,
+	RetryInfraAttemptsPropertyKey:    true,
+	RetryBackoffPropertyKey:          true,
+	RetryMaxBackoffPropertyKey:       true,
+	driver.TimeoutPropertyKey:        true,
+	driver.CommandTimeoutPropertyKey: true,
+}
+
+// validateConfigKeys rejects the keys nothing reads, such as misspelled ones
+func validateConfigKeys(config map[string]string) error {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	// the keys are sorted so the same error is reported for the same config
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !configKeys[key] && !strings.HasPrefix(key, driver.DockerPropertyPrefix) {
+			return errors.AddErrorContext(errors.BadRequest.Newf("Unknown config [%s]", key), key, "unknown")
+		}
+	}
+	return nil
+}
+
+// taskPriority is the priority declared by the task, or else the one of its job
+func taskPriority(task *storage.Task, jobPriority int) int {
+	value, err := task.GetConfig(PriorityPropertyKey)
+	if err != nil {
+		return jobPriority
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return jobPriority
+	}
+	return priority
+}
+
+// taskEstimate is the run time declared by the task. Tasks without one go after the ones with.
+func taskEstimate(task *storage.Task) time.Duration {
+	value, err := task.GetConfig(EstimatePropertyKey)
+	if err != nil {
+		return time.Duration(1<<63 - 1)
+	}
+	estimate, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Duration(1<<63 - 1)
+	}
+	return estimate
+}
+
+// fifo schedules the tasks in the order they arrived
+type fifo struct {
+	tasks []*PendingTask
+}
+
+func (f *fifo) Name() string {
+	return FifoPolicy
+}
+
+// Push keeps the tasks ordered by arrival, so a task that goes back to wait keeps its place
+func (f *fifo) Push(t *PendingTask) {
+	i := len(f.tasks)
+	for i > 0 && f.tasks[i-1].Seq > t.Seq {
+		i--
+	}
+	f.tasks = append(f.tasks, nil)
+	copy(f.tasks[i+1:], f.tasks[i:])
+	f.tasks[i] = t
+}
+
+func (f *fifo) Pop() *PendingTask {
+	if len(f.tasks) == 0 {
+		return nil
+	}
+	t := f.tasks[0]
+	f.tasks[0] = nil
+	f.tasks = f.tasks[1:]
+	return t
+}
+
+func (f *fifo) Remove(match func(*storage.Task) bool) {
+	kept := f.tasks[:0]
+	for _, t := range f.tasks {
+		if !match(t.Task) {
+			kept = append(kept, t)
+		}
+	}
+	f.tasks = kept
+}
+
+func (f *fifo) Len() int {
+	return len(f.tasks)
+}
+
+// heapPolicy schedules first the tasks that come before by its criterion, and in the
+// order they arrived among the tied ones
+type heapPolicy struct {
+	name   string
+	before func(a, b *PendingTask) bool
+	tasks  []*PendingTask
+}
+
+func newHeapPolicy(name string, before func(a, b *PendingTask) bool) *heapPolicy {
+	return &heapPolicy{name: name, before: before}
+}
+
+func (h *heapPolicy) Name() string {
+	return h.name
+}
+
+func (h *heapPolicy) Push(t *PendingTask) {
+	heap.Push((*pendingHeap)(h), t)
+}
+
+func (h *heapPolicy) Pop() *PendingTask {
+	if len(h.tasks) == 0 {
+		return nil
+	}
+	return heap.Pop((*pendingHeap)(h)).(*PendingTask)
+}
+
+func (h *heapPolicy) Remove(match func(*storage.Task) bool) {
+	kept := h.tasks[:0]
+	for _, t := range h.tasks {
+		if !match(t.Task) {
+			kept = append(kept, t)
+		}
+	}
+	h.tasks = kept
+	heap.Init((*pendingHeap)(h))
+}
+
+func (h *heapPolicy) Len() int {
+	return len(h.tasks)
+}
+
+// pendingHeap implements heap.Interface over the tasks of a heapPolicy
+type pendingHeap heapPolicy
+
+func (p *pendingHeap) Len() int {
+	return len(p.tasks)
+}
+
+func (p *pendingHeap) Less(i, j int) bool {
+	a, b := p.tasks[i], p.tasks[j]
+	if p.before(a, b) {
+		return true
+	}
+	if p.before(b, a) {
+		return false
+	}
+	return a.Seq < b.Seq
+}
+
+func (p *pendingHeap) Swap(i, j int) {
+	p.tasks[i], p.tasks[j] = p.tasks[j], p.tasks[i]
+}
+
+func (p *pendingHeap) Push(x interface{}) {
+	p.tasks = append(p.tasks, x.(*PendingTask))
+}
+
+func (p *pendingHeap) Pop() interface{} {
+	n := len(p.tasks)
+	t := p.tasks[n-1]
+	p.tasks[n-1] = nil
+	p.tasks = p.tasks[:n-1]
+	return t
+}
+
+// roundRobin takes one task of each job in turn, so a job with many tasks does not hold
+// back the ones that arrived after it. The tasks of a job are taken in the order they arrived.
+type roundRobin struct {
+	queues map[uint]*fifo
+	// jobs is the order in which the jobs take turns
+	jobs []uint
+}
+
+func (r *roundRobin) Name() string {
+	return RoundRobinPolicy
+}
+
+func (r *roundRobin) Push(t *PendingTask) {
+	q, ok := r.queues[t.Task.JobID]
+	if !ok {
+		q = &fifo{}
+		r.queues[t.Task.JobID] = q
+		r.jobs = append(r.jobs, t.Task.JobID)
+	}
+	q.Push(t)
+}
+
+func (r *roundRobin) Pop() *PendingTask {
+	if len(r.jobs) == 0 {
+		return nil
+	}
+	jobID := r.jobs[0]
+	q := r.queues[jobID]
+	t := q.Pop()
+	r.jobs = r.jobs[1:]
+	if q.Len() > 0 {
+		r.jobs = append(r.jobs, jobID)
+	} else {
+		delete(r.queues, jobID)
+	}
+	return t
+}
+
+func (r *roundRobin) Remove(match func(*storage.Task) bool) {
+	jobs := r.jobs[:0]
+	for _, jobID := range r.jobs {
+		q := r.queues[jobID]
+		q.Remove(match)
+		if q.Len() > 0 {
+			jobs = append(jobs, jobID)
+		} else {
+			delete(r.queues, jobID)
+		}
+	}
+	r.jobs = jobs
+}
+
+func (r *roundRobin) Len() int {
+	n := 0
+	for _, q := range r.queues {
+		n += q.Len()
+	}
+	return n
+}
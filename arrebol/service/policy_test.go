@@ -0,0 +1,131 @@
+package service
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"reflect"
+	"testing"
+)
+
+func newTask(id, jobID uint, config map[string]string) *storage.Task {
+	task := &storage.Task{Model: gorm.Model{ID: id}, JobID: jobID}
+	for key, value := range config {
+		task.Config = append(task.Config, storage.TaskConfig{Key: key, Value: value})
+	}
+	return task
+}
+
+func order(t *testing.T, name string, tasks []*storage.Task) []uint {
+	policy, err := NewPolicy(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, task := range tasks {
+		policy.Push(&PendingTask{Task: task, Seq: uint64(i), Priority: taskPriority(task, 0)})
+	}
+	var ids []uint
+	for p := policy.Pop(); p != nil; p = policy.Pop() {
+		ids = append(ids, p.Task.ID)
+	}
+	return ids
+}
+
+func TestFifoPolicy(t *testing.T) {
+	f, _ := NewPolicy(FifoPolicy)
+	f.Push(&PendingTask{Task: newTask(1, 1, nil), Seq: 1})
+	f.Push(&PendingTask{Task: newTask(3, 1, nil), Seq: 3})
+	// a task that goes back to wait keeps its place
+	f.Push(&PendingTask{Task: newTask(2, 1, nil), Seq: 2})
+
+	var ids []uint
+	for p := f.Pop(); p != nil; p = f.Pop() {
+		ids = append(ids, p.Task.ID)
+	}
+	if want := []uint{1, 2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("order = %v, want %v", ids, want)
+	}
+}
+
+func TestPriorityPolicy(t *testing.T) {
+	ids := order(t, PriorityPolicy, []*storage.Task{
+		newTask(1, 1, nil),
+		newTask(2, 1, map[string]string{PriorityPropertyKey: "5"}),
+		newTask(3, 2, map[string]string{PriorityPropertyKey: "-1"}),
+		newTask(4, 2, map[string]string{PriorityPropertyKey: "5"}),
+	})
+	if want := []uint{2, 4, 1, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("order = %v, want %v", ids, want)
+	}
+}
+
+func TestSJFPolicy(t *testing.T) {
+	ids := order(t, SJFPolicy, []*storage.Task{
+		newTask(1, 1, nil),
+		newTask(2, 1, map[string]string{EstimatePropertyKey: "1h"}),
+		newTask(3, 2, map[string]string{EstimatePropertyKey: "30s"}),
+		newTask(4, 2, map[string]string{EstimatePropertyKey: "10m"}),
+	})
+	if want := []uint{3, 4, 2, 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("order = %v, want %v", ids, want)
+	}
+}
+
+func TestRoundRobinPolicy(t *testing.T) {
+	ids := order(t, RoundRobinPolicy, []*storage.Task{
+		newTask(1, 1, nil),
+		newTask(2, 1, nil),
+		newTask(3, 1, nil),
+		newTask(4, 2, nil),
+		newTask(5, 3, nil),
+		newTask(6, 2, nil),
+	})
+	if want := []uint{1, 4, 5, 2, 6, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("order = %v, want %v", ids, want)
+	}
+}
+
+func TestPolicyRemove(t *testing.T) {
+	for _, name := range []string{FifoPolicy, PriorityPolicy, SJFPolicy, RoundRobinPolicy} {
+		policy, _ := NewPolicy(name)
+		for i, jobID := range []uint{1, 2, 1, 2} {
+			policy.Push(&PendingTask{Task: newTask(uint(i+1), jobID, nil), Seq: uint64(i)})
+		}
+		policy.Remove(func(task *storage.Task) bool { return task.JobID == 1 })
+
+		if policy.Len() != 2 {
+			t.Errorf("%s: len = %d, want 2", name, policy.Len())
+		}
+		for p := policy.Pop(); p != nil; p = policy.Pop() {
+			if p.Task.JobID == 1 {
+				t.Errorf("%s: task [%d] of the removed job was kept", name, p.Task.ID)
+			}
+		}
+	}
+}
+
+func TestValidateTaskConfig(t *testing.T) {
+	valid := map[string]string{PriorityPropertyKey: "-3", EstimatePropertyKey: "90s"}
+	if err := ValidateTaskConfig(valid); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, config := range []map[string]string{
+		{PriorityPropertyKey: "high"},
+		{EstimatePropertyKey: "soon"},
+		{EstimatePropertyKey: "-1m"},
+		{"priorty": "3"},
+		{"timout": "1h"},
+		{"docker_imag": "ubuntu"},
+	} {
+		if err := ValidateTaskConfig(config); err == nil {
+			t.Errorf("%v should be rejected", config)
+		}
+	}
+	err := ValidateTaskConfig(map[string]string{PriorityPropertyKey: "1", "timout": "1h", "estimat": "1m"})
+	if field := errors.GetErrorContext(err)["field"]; field != "estimat" {
+		t.Errorf("field = %q, want the first unknown key", field)
+	}
+	if _, err := NewPolicy("lottery"); err != UnknownPolicyErr {
+		t.Errorf("err = %v, want %v", err, UnknownPolicyErr)
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/worker"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	HeartbeatTimeoutKey     = "WORKER_HEARTBEAT_TIMEOUT"
+	DefaultHeartbeatTimeout = 1 * time.Minute
+	ReapingPeriodTime       = 15 * time.Second
+)
+
+// The Reaper looks after the remote workers. Workers that stop sending heartbeats
+// are marked as lost and the tasks they were running, as well as the tasks whose
+// lease has expired, go back to the scheduler of their queues.
+type Reaper struct {
+	dispatcher *Dispatcher
+	timeout    time.Duration
+}
+
+func NewReaper(dispatcher *Dispatcher) *Reaper {
+	timeout, err := time.ParseDuration(os.Getenv(HeartbeatTimeoutKey))
+	if err != nil || timeout <= 0 {
+		timeout = DefaultHeartbeatTimeout
+	}
+	return &Reaper{
+		dispatcher: dispatcher,
+		timeout:    timeout,
+	}
+}
+
+func (r *Reaper) Start() {
+	log.Printf("Reaper started, workers silent for %s will be considered lost", r.timeout)
+	for range time.Tick(ReapingPeriodTime) {
+		r.reap()
+	}
+}
+
+func (r *Reaper) reap() {
+	workers, err := storage.DB.RetrieveSilentWorkers(time.Now().Add(-r.timeout))
+	if err != nil {
+		log.Printf("Error while retrieving silent workers: %s", err.Error())
+		return
+	}
+
+	for _, w := range workers {
+		workerID := w.ID.String()
+		if err = storage.DB.SetWorkerState(workerID, worker.Lost); err != nil {
+			log.Printf("Error while marking worker [%s] as lost: %s", workerID, err.Error())
+			continue
+		}
+		log.Printf("Worker [%s] was lost, last seen at %s", workerID, w.LastSeen.Format(time.RFC3339))
+		tasks, err := storage.DB.RequeueWorkerTasks(workerID)
+		if err != nil {
+			log.Printf("Error while requeueing tasks of worker [%s]: %s", workerID, err.Error())
+			continue
+		}
+		r.requeue(tasks)
+	}
+
+	tasks, err := storage.DB.RequeueExpiredTasks()
+	if err != nil {
+		log.Printf("Error while requeueing tasks with expired lease: %s", err.Error())
+		return
+	}
+	r.requeue(tasks)
+}
+
+func (r *Reaper) requeue(tasks []*storage.Task) {
+	for _, task := range tasks {
+		r.dispatcher.Requeue(task)
+	}
+}
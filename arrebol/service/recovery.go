@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"github.com/ufcg-lsd/arrebol-pb/docker"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"log"
+	"os"
+)
+
+// RecoveryPolicy tells what happens, after a restart, to the tasks that were running
+// on the workers of this server when it went down.
+type RecoveryPolicy uint
+
+const RecoveryPolicyKey = "RECOVERY_POLICY"
+
+const (
+	Requeue RecoveryPolicy = iota
+	Fail
+)
+
+func (p RecoveryPolicy) String() string {
+	return [...]string{"requeue", "fail"}[p]
+}
+
+func recoveryPolicy() RecoveryPolicy {
+	switch os.Getenv(RecoveryPolicyKey) {
+	case Fail.String():
+		return Fail
+	case "", Requeue.String():
+		return Requeue
+	default:
+		log.Printf("Unknown recovery policy [%s], using [%s]", os.Getenv(RecoveryPolicyKey), Requeue)
+		return Requeue
+	}
+}
+
+// recover hires a supervisor for every persisted queue and hands back to it
+// the jobs that were not completed before the server went down.
+func (d *Dispatcher) recover() {
+	policy := recoveryPolicy()
+	sweepContainers()
+
+	queues, err := storage.DB.RetrieveQueues()
+	if err != nil {
+		log.Printf("Unable to recover the queues: %s", err.Error())
+		return
+	}
+
+	for _, queue := range queues {
+		seedNode(queue)
+		super := d.HireSupervisor(queue)
+		go super.Start()
+
+		jobs, err := storage.DB.RetrieveJobsByQueueID(queue.ID)
+		if err != nil {
+			log.Printf("Unable to recover the jobs of queue [%d]: %s", queue.ID, err.Error())
+			continue
+		}
+		for _, job := range jobs {
+			if job.State.IsCompleted() {
+				continue
+			}
+			log.Printf("Recovering job [%d] of queue [%d] with policy [%s]", job.ID, queue.ID, policy)
+			reconcile(job, policy, storage.DB)
+			super.Resume(job)
+		}
+	}
+}
+
+// seedNode registers the default node to a queue that never had one, such as the ones
+// created before nodes were introduced. Queues whose nodes were all drained are left alone.
+func seedNode(queue *storage.Queue) {
+	had, err := storage.DB.QueueHadNode(queue.ID)
+	if err != nil {
+		log.Printf("Unable to retrieve the nodes of queue [%d]: %s", queue.ID, err.Error())
+		return
+	}
+	if had {
+		return
+	}
+	log.Printf("Registering the default node to queue [%d]", queue.ID)
+	if err = SeedNode(queue.ID); err != nil {
+		log.Printf("Unable to register the default node to queue [%d]: %s", queue.ID, err.Error())
+	}
+}
+
+// sweepContainers removes the containers left behind on the docker nodes by the tasks that
+// were running when the server went down, which are recovered afterwards
+func sweepContainers() {
+	addresses := make(map[string]bool)
+	if os.Getenv(DriverKey) == DockerNodeDriver {
+		addresses[os.Getenv(WorkerAddressKey)] = true
+	}
+	nodes, err := storage.DB.RetrieveNodes()
+	if err != nil {
+		log.Printf("Unable to retrieve the nodes: %s", err.Error())
+	}
+	for _, node := range nodes {
+		if node.Driver == DockerNodeDriver {
+			addresses[node.Address] = true
+		}
+	}
+	for address := range addresses {
+		sweepNode(address)
+	}
+}
+
+func sweepNode(address string) {
+	ctx := context.Background()
+	cli, err := docker.NewDockerClient(address)
+	if err != nil {
+		log.Printf("Unable to connect to [%s]: %s", address, err.Error())
+		return
+	}
+	ids, err := docker.ListManagedContainers(ctx, cli)
+	if err != nil {
+		log.Printf("Unable to list the containers left behind on [%s]: %s", address, err.Error())
+		return
+	}
+	for _, id := range ids {
+		if err = docker.RemoveContainer(ctx, cli, id); err != nil {
+			log.Printf("Unable to remove the container [%s] left behind: %s", id, err.Error())
+		}
+	}
+}
+
+// taskRecovery changes the states of the tasks found running after a restart
+type taskRecovery interface {
+	RequeueTask(taskID uint) (*storage.Task, error)
+	FailTask(taskID uint) error
+}
+
+// reconcile deals with the tasks that were running on the in-process workers. Tasks leased
+// by remote workers are left alone, since they may still be running; the reaper takes care of them.
+func reconcile(job *storage.Job, policy RecoveryPolicy, tasks taskRecovery) {
+	for i, task := range job.Tasks {
+		if task.State != storage.TaskRunning || task.LeaseExpiresAt != nil {
+			continue
+		}
+		switch policy {
+		case Requeue:
+			requeued, err := tasks.RequeueTask(task.ID)
+			if err != nil {
+				log.Printf("Unable to requeue task [%d]: %s", task.ID, err.Error())
+				continue
+			}
+			job.Tasks[i] = requeued
+		case Fail:
+			if err := tasks.FailTask(task.ID); err != nil {
+				log.Printf("Unable to fail task [%d]: %s", task.ID, err.Error())
+				continue
+			}
+			task.State = storage.TaskFailed
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"github.com/jinzhu/gorm"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecoveryPolicy(t *testing.T) {
+	defer os.Unsetenv(RecoveryPolicyKey)
+	cases := map[string]RecoveryPolicy{
+		"":        Requeue,
+		"requeue": Requeue,
+		"fail":    Fail,
+		"retry":   Requeue,
+	}
+	for value, want := range cases {
+		os.Setenv(RecoveryPolicyKey, value)
+		if got := recoveryPolicy(); got != want {
+			t.Errorf("%q: policy = %s, want %s", value, got, want)
+		}
+	}
+}
+
+// fakeRecovery records the tasks changed by reconcile, failing on the ones it is told to
+type fakeRecovery struct {
+	requeued, failed []uint
+	broken           map[uint]bool
+}
+
+func (f *fakeRecovery) RequeueTask(taskID uint) (*storage.Task, error) {
+	if f.broken[taskID] {
+		return nil, errors.New("the database is gone")
+	}
+	f.requeued = append(f.requeued, taskID)
+	return &storage.Task{Model: gorm.Model{ID: taskID}, State: storage.TaskPending}, nil
+}
+
+func (f *fakeRecovery) FailTask(taskID uint) error {
+	if f.broken[taskID] {
+		return errors.New("the database is gone")
+	}
+	f.failed = append(f.failed, taskID)
+	return nil
+}
+
+// recoveringJob has a pending task, a running one, one leased by a remote worker and
+// a running one the storage fails to change
+func recoveringJob() *storage.Job {
+	lease := time.Now().Add(time.Minute)
+	return &storage.Job{Tasks: []*storage.Task{
+		{Model: gorm.Model{ID: 1}, State: storage.TaskPending},
+		{Model: gorm.Model{ID: 2}, State: storage.TaskRunning},
+		{Model: gorm.Model{ID: 3}, State: storage.TaskRunning, LeaseExpiresAt: &lease},
+		{Model: gorm.Model{ID: 4}, State: storage.TaskRunning},
+	}}
+}
+
+func TestReconcileRequeue(t *testing.T) {
+	job := recoveringJob()
+	f := &fakeRecovery{broken: map[uint]bool{4: true}}
+	reconcile(job, Requeue, f)
+
+	if len(f.requeued) != 1 || f.requeued[0] != 2 || len(f.failed) != 0 {
+		t.Fatalf("requeued %v and failed %v, want only task 2 requeued", f.requeued, f.failed)
+	}
+	want := []storage.TaskState{storage.TaskPending, storage.TaskPending, storage.TaskRunning, storage.TaskRunning}
+	for i, task := range job.Tasks {
+		if task.State != want[i] {
+			t.Errorf("task %d is %s, want %s", task.ID, task.State, want[i])
+		}
+	}
+}
+
+func TestReconcileFail(t *testing.T) {
+	job := recoveringJob()
+	f := &fakeRecovery{broken: map[uint]bool{4: true}}
+	reconcile(job, Fail, f)
+
+	if len(f.failed) != 1 || f.failed[0] != 2 || len(f.requeued) != 0 {
+		t.Fatalf("failed %v and requeued %v, want only task 2 failed", f.failed, f.requeued)
+	}
+	want := []storage.TaskState{storage.TaskPending, storage.TaskFailed, storage.TaskRunning, storage.TaskRunning}
+	for i, task := range job.Tasks {
+		if task.State != want[i] {
+			t.Errorf("task %d is %s, want %s", task.ID, task.State, want[i])
+		}
+	}
+}
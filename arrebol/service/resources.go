@@ -0,0 +1,166 @@
+package service
+
+import (
+	"github.com/docker/go-units"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/constraint"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/worker"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The task config keys declaring what a task needs from the worker running it
+const (
+	// CPUPropertyKey is the number of cores, e.g. "2" or "0.5"
+	CPUPropertyKey = "cpu"
+	// MemoryPropertyKey is the size of the memory, e.g. "512m" or "2g"
+	MemoryPropertyKey = "memory"
+	// DiskPropertyKey is the size of the disk, e.g. "10g"
+	DiskPropertyKey = "disk"
+	// ConstraintsPropertyKey is an expression on the labels of the worker, e.g. "zone == a && gpu"
+	ConstraintsPropertyKey = "constraints"
+)
+
+// The env keys setting the capacity of each local worker. A capacity left unset is not accounted.
+const (
+	WorkerCPUKey    = "WORKER_CPU"
+	WorkerMemoryKey = "WORKER_MEMORY"
+	WorkerDiskKey   = "WORKER_DISK"
+	// WorkerLabelsKey labels the local workers, e.g. "zone=a,gpu=t4"
+	WorkerLabelsKey = "WORKER_LABELS"
+)
+
+// Resources is an amount of CPU cores and of bytes of memory and disk. As a capacity,
+// a zero amount means the resource is not accounted.
+type Resources struct {
+	CPU    float64
+	Memory int64
+	Disk   int64
+}
+
+// Add sums the resources
+func (r Resources) Add(o Resources) Resources {
+	return Resources{CPU: r.CPU + o.CPU, Memory: r.Memory + o.Memory, Disk: r.Disk + o.Disk}
+}
+
+func (r Resources) sub(o Resources) Resources {
+	return Resources{CPU: r.CPU - o.CPU, Memory: r.Memory - o.Memory, Disk: r.Disk - o.Disk}
+}
+
+// Fits tells if the requirement fits in the capacity once the allocated resources are taken
+func (r Resources) Fits(requirement, allocated Resources) bool {
+	return (r.CPU == 0 || allocated.CPU+requirement.CPU <= r.CPU) &&
+		(r.Memory == 0 || allocated.Memory+requirement.Memory <= r.Memory) &&
+		(r.Disk == 0 || allocated.Disk+requirement.Disk <= r.Disk)
+}
+
+// leftover is the share of the capacity that would be left free, summed over the resources.
+// The resources not accounted count as entirely free, so the tightest fit has the lowest one.
+func (r Resources) leftover(requirement, allocated Resources) float64 {
+	share := func(capacity, used float64) float64 {
+		if capacity == 0 {
+			return 1
+		}
+		return (capacity - used) / capacity
+	}
+	used := allocated.Add(requirement)
+	return share(r.CPU, used.CPU) + share(float64(r.Memory), float64(used.Memory)) + share(float64(r.Disk), float64(used.Disk))
+}
+
+// TaskRequirements is what the task declares to need, nothing for what it does not declare
+func TaskRequirements(task *storage.Task) Resources {
+	var r Resources
+	if value, err := task.GetConfig(CPUPropertyKey); err == nil {
+		r.CPU, _ = strconv.ParseFloat(value, 64)
+	}
+	if value, err := task.GetConfig(MemoryPropertyKey); err == nil {
+		r.Memory, _ = units.RAMInBytes(value)
+	}
+	if value, err := task.GetConfig(DiskPropertyKey); err == nil {
+		r.Disk, _ = units.RAMInBytes(value)
+	}
+	return r
+}
+
+// MatchesLabels tells if the labels satisfy the constraints of the task, if it has any
+func MatchesLabels(task *storage.Task, labels map[string]string) bool {
+	value, err := task.GetConfig(ConstraintsPropertyKey)
+	if err != nil {
+		return true
+	}
+	expr, err := constraint.Parse(value)
+	return err == nil && expr.Matches(labels)
+}
+
+// unfit tells why a worker with the capacity and the labels could never run the task,
+// empty when it could once it is idle
+func unfit(task *storage.Task, capacity Resources, labels map[string]string) string {
+	if !MatchesLabels(task, labels) {
+		value, _ := task.GetConfig(ConstraintsPropertyKey)
+		return "no worker has labels satisfying [" + value + "]"
+	}
+	if !capacity.Fits(TaskRequirements(task), Resources{}) {
+		return "no worker matching the task has the capacity it requires"
+	}
+	return ""
+}
+
+// RemoteCapacity is the capacity a remote worker registered with, which does not account disk
+func RemoteCapacity(w *worker.Worker) Resources {
+	return Resources{CPU: float64(w.VCPU), Memory: int64(w.RAM) * units.MiB}
+}
+
+// validateRequirements checks the keys of a task config declaring its requirements
+func validateRequirements(config map[string]string) error {
+	if value, ok := config[CPUPropertyKey]; ok {
+		if cpu, err := strconv.ParseFloat(value, 64); err != nil || cpu <= 0 {
+			return malformedConfig(CPUPropertyKey, "must be a positive number of cores, e.g. 2 or 0.5")
+		}
+	}
+	if value, ok := config[ConstraintsPropertyKey]; ok {
+		if _, err := constraint.Parse(value); err != nil {
+			return malformedConfig(ConstraintsPropertyKey, err.Error())
+		}
+	}
+	for _, key := range []string{MemoryPropertyKey, DiskPropertyKey} {
+		if value, ok := config[key]; ok {
+			if size, err := units.RAMInBytes(value); err != nil || size <= 0 {
+				return malformedConfig(key, "must be a positive size, e.g. 512m or 2g")
+			}
+		}
+	}
+	return nil
+}
+
+// localCapacity is the capacity of each local worker, as set by the env
+func localCapacity() Resources {
+	var c Resources
+	c.CPU, _ = strconv.ParseFloat(os.Getenv(WorkerCPUKey), 64)
+	c.Memory, _ = units.RAMInBytes(os.Getenv(WorkerMemoryKey))
+	c.Disk, _ = units.RAMInBytes(os.Getenv(WorkerDiskKey))
+	return c
+}
+
+// localLabels are the labels of each local worker, as set by the env
+func localLabels() map[string]string {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(WorkerLabelsKey), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			log.Printf("Ignoring the malformed worker label [%s], use key=value", pair)
+			continue
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return labels
+}
+
+func malformedConfig(key, reason string) error {
+	return errors.AddErrorContext(errors.BadRequest.Newf("Malformed config [%s]: %s", key, reason), key, reason)
+}
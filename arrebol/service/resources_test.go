@@ -0,0 +1,94 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
+	"testing"
+)
+
+func TestResourcesFits(t *testing.T) {
+	capacity := Resources{CPU: 4, Memory: 8 << 30}
+	allocated := Resources{CPU: 3, Memory: 2 << 30}
+
+	if !capacity.Fits(Resources{CPU: 1, Memory: 6 << 30, Disk: 1 << 40}, allocated) {
+		t.Error("a requirement filling the capacity, on a resource not accounted too, should fit")
+	}
+	if capacity.Fits(Resources{CPU: 1.5}, allocated) {
+		t.Error("a requirement over the free CPU should not fit")
+	}
+	if capacity.Fits(Resources{Memory: 7 << 30}, allocated) {
+		t.Error("a requirement over the free memory should not fit")
+	}
+}
+
+func TestBestFitPlacement(t *testing.T) {
+	policy, _ := NewPolicy(FifoPolicy)
+	s := NewScheduler(1, policy)
+	large := NewWorker(&driver.RawDriver{}, 4, Resources{CPU: 16}, nil)
+	small := NewWorker(&driver.RawDriver{}, 4, Resources{CPU: 4}, nil)
+	s.workers = []*Worker{large, small}
+
+	place := func(cpu string) *Worker {
+		plan := s.inferPlanForTask(newTask(1, 1, map[string]string{CPUPropertyKey: cpu}))
+		if plan == nil {
+			return nil
+		}
+		return plan.worker
+	}
+
+	if w := place("2"); w != small {
+		t.Fatal("a small task should go to the worker it fits the tightest")
+	}
+	if w := place("2"); w != small {
+		t.Fatal("a second small task should share the worker")
+	}
+	if w := place("2"); w != large {
+		t.Fatal("a task not fitting the small worker anymore should go to the large one")
+	}
+	if w := place("15"); w != nil {
+		t.Fatal("a task larger than every free capacity should wait")
+	}
+}
+
+func TestValidateRequirements(t *testing.T) {
+	if err := ValidateTaskConfig(map[string]string{CPUPropertyKey: "0.5", MemoryPropertyKey: "512m", DiskPropertyKey: "10g"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, config := range []map[string]string{
+		{CPUPropertyKey: "two"},
+		{CPUPropertyKey: "0"},
+		{MemoryPropertyKey: "lots"},
+		{DiskPropertyKey: "-1g"},
+	} {
+		if err := ValidateTaskConfig(config); err == nil {
+			t.Errorf("%v should be rejected", config)
+		}
+	}
+}
+
+func TestPlacementConstraints(t *testing.T) {
+	policy, _ := NewPolicy(FifoPolicy)
+	s := NewScheduler(1, policy)
+	gpu := NewWorker(&driver.RawDriver{}, 1, Resources{CPU: 4}, map[string]string{"gpu": "t4", "zone": "a"})
+	plain := NewWorker(&driver.RawDriver{}, 1, Resources{CPU: 4}, map[string]string{"zone": "b"})
+	s.workers = []*Worker{plain, gpu}
+
+	task := newTask(1, 1, map[string]string{ConstraintsPropertyKey: "gpu in (t4, a100) && zone != b"})
+	if plan := s.inferPlanForTask(task); plan == nil || plan.worker != gpu {
+		t.Fatal("the task should be placed on the worker satisfying its constraints")
+	}
+	if s.unschedulable(task) != "" {
+		t.Error("a task some worker satisfies should not be unschedulable")
+	}
+	if plan := s.inferPlanForTask(task); plan != nil {
+		t.Error("the task should wait while the only worker satisfying it is busy")
+	}
+
+	never := newTask(2, 1, map[string]string{ConstraintsPropertyKey: "zone == c"})
+	if reason := s.unschedulable(never); reason == "" {
+		t.Error("a task no worker satisfies should be unschedulable")
+	}
+	large := newTask(3, 1, map[string]string{CPUPropertyKey: "8"})
+	if reason := s.unschedulable(large); reason == "" {
+		t.Error("a task larger than every worker should be unschedulable")
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The task config keys of the retry policy of a task. A failed task is final unless it
+// declares more than one attempt.
+const (
+	// RetryAttemptsPropertyKey is how many times the commands of the task may be attempted
+	RetryAttemptsPropertyKey = "retry_attempts"
+	// RetryExitCodesPropertyKey is a comma-separated list of the exit codes worth another
+	// attempt, e.g. "1,137". Any failure is retried when it is not declared.
+	RetryExitCodesPropertyKey = "retry_exit_codes"
+	// RetryInfraAttemptsPropertyKey is how many times the task may be attempted again when the
+	// worker, rather than the commands, fails. These attempts do not count as the ones above.
+	RetryInfraAttemptsPropertyKey = "retry_infra_attempts"
+	// RetryBackoffPropertyKey is the wait before the second attempt, doubled on each next one
+	RetryBackoffPropertyKey = "retry_backoff"
+	// RetryMaxBackoffPropertyKey bounds the wait between two attempts
+	RetryMaxBackoffPropertyKey = "retry_max_backoff"
+)
+
+const (
+	DefaultRetryInfraAttempts = 3
+	DefaultRetryBackoff       = 10 * time.Second
+	DefaultRetryMaxBackoff    = 10 * time.Minute
+)
+
+// RetryPolicy tells if and when a failed task is attempted again
+type RetryPolicy struct {
+	Attempts      int
+	InfraAttempts int
+	// ExitCodes are the retryable exit codes, nil meaning any
+	ExitCodes  map[int]bool
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// TaskRetryPolicy reads the retry policy declared by the task
+func TaskRetryPolicy(task *storage.Task) RetryPolicy {
+	p := RetryPolicy{
+		Attempts:      1,
+		InfraAttempts: DefaultRetryInfraAttempts,
+		Backoff:       DefaultRetryBackoff,
+		MaxBackoff:    DefaultRetryMaxBackoff,
+	}
+	if value, err := task.GetConfig(RetryAttemptsPropertyKey); err == nil {
+		p.Attempts, _ = strconv.Atoi(value)
+	}
+	if value, err := task.GetConfig(RetryInfraAttemptsPropertyKey); err == nil {
+		p.InfraAttempts, _ = strconv.Atoi(value)
+	}
+	if value, err := task.GetConfig(RetryExitCodesPropertyKey); err == nil {
+		p.ExitCodes, _ = parseExitCodes(value)
+	}
+	if value, err := task.GetConfig(RetryBackoffPropertyKey); err == nil {
+		p.Backoff, _ = time.ParseDuration(value)
+	}
+	if value, err := task.GetConfig(RetryMaxBackoffPropertyKey); err == nil {
+		p.MaxBackoff, _ = time.ParseDuration(value)
+	}
+	return p
+}
+
+// Next tells how long to wait before attempting again the task whose last attempt has just
+// failed, or false when the task must stay failed
+func (p RetryPolicy) Next(attempts []*storage.TaskAttempt) (time.Duration, bool) {
+	if len(attempts) == 0 {
+		return 0, false
+	}
+	last := attempts[len(attempts)-1]
+	if last.State != storage.TaskFailed {
+		return 0, false
+	}
+
+	infra, user := 0, 0
+	for _, a := range attempts {
+		if a.Infrastructure {
+			infra++
+		} else {
+			user++
+		}
+	}
+	if last.Infrastructure {
+		if infra > p.InfraAttempts {
+			return 0, false
+		}
+	} else if user >= p.Attempts || !p.retryable(last.ExitCodes) {
+		return 0, false
+	}
+	return p.backoff(len(attempts)), true
+}
+
+// retryable tells if the exit codes of the failed commands are all worth another attempt
+func (p RetryPolicy) retryable(codes storage.ExitCodes) bool {
+	if p.ExitCodes == nil {
+		return true
+	}
+	failed := false
+	for _, code := range codes {
+		if code == 0 || code == -1 {
+			continue
+		}
+		failed = true
+		if !p.ExitCodes[code] {
+			return false
+		}
+	}
+	return failed
+}
+
+// backoff is the wait after the n-th attempt, doubling on each one up to the bound. The wait
+// is drawn from its upper half so that tasks failing together are not retried together.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// validateRetryPolicy checks the keys of a task config declaring its retry policy
+func validateRetryPolicy(config map[string]string) error {
+	for _, key := range []string{RetryAttemptsPropertyKey, RetryInfraAttemptsPropertyKey} {
+		if value, ok := config[key]; ok {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 || (key == RetryAttemptsPropertyKey && n == 0) {
+				return malformedConfig(key, "must be a positive integer")
+			}
+		}
+	}
+	if value, ok := config[RetryExitCodesPropertyKey]; ok {
+		if _, ok := parseExitCodes(value); !ok {
+			return malformedConfig(RetryExitCodesPropertyKey, "must be a comma-separated list of exit codes, e.g. 1,137")
+		}
+	}
+	for _, key := range []string{RetryBackoffPropertyKey, RetryMaxBackoffPropertyKey} {
+		if value, ok := config[key]; ok {
+			if d, err := time.ParseDuration(value); err != nil || d < 0 {
+				return malformedConfig(key, "must be a duration, e.g. 30s")
+			}
+		}
+	}
+	return nil
+}
+
+func parseExitCodes(value string) (map[int]bool, bool) {
+	codes := make(map[int]bool)
+	for _, field := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 0 || code > 255 {
+			return nil, false
+		}
+		codes[code] = true
+	}
+	return codes, true
+}
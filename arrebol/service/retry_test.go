@@ -0,0 +1,77 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"testing"
+	"time"
+)
+
+func failed(infra bool, codes ...int) *storage.TaskAttempt {
+	return &storage.TaskAttempt{State: storage.TaskFailed, Infrastructure: infra, ExitCodes: codes}
+}
+
+func TestRetryPolicyNext(t *testing.T) {
+	task := newTask(1, 1, map[string]string{
+		RetryAttemptsPropertyKey:      "2",
+		RetryExitCodesPropertyKey:     "1, 137",
+		RetryInfraAttemptsPropertyKey: "1",
+	})
+	p := TaskRetryPolicy(task)
+
+	cases := []struct {
+		name     string
+		attempts []*storage.TaskAttempt
+		retry    bool
+	}{
+		{"retryable exit code", []*storage.TaskAttempt{failed(false, 0, 137)}, true},
+		{"exit code not retryable", []*storage.TaskAttempt{failed(false, 0, 2)}, false},
+		{"attempts exhausted", []*storage.TaskAttempt{failed(false, 1), failed(false, 1)}, false},
+		{"infrastructure does not count as attempts", []*storage.TaskAttempt{failed(true), failed(false, 1)}, true},
+		{"infrastructure attempts exhausted", []*storage.TaskAttempt{failed(true), failed(true)}, false},
+		{"finished", []*storage.TaskAttempt{{State: storage.TaskFinished}}, false},
+		{"cancelled", []*storage.TaskAttempt{{State: storage.TaskCancelled}}, false},
+	}
+	for _, c := range cases {
+		if _, retry := p.Next(c.attempts); retry != c.retry {
+			t.Errorf("%s: retry = %t, want %t", c.name, retry, c.retry)
+		}
+	}
+
+	if _, retry := TaskRetryPolicy(newTask(2, 1, nil)).Next([]*storage.TaskAttempt{failed(false, 1)}); retry {
+		t.Error("a task declaring no attempts should not be retried")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for n, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(n + 1); d < max/2 || d > max {
+				t.Fatalf("backoff after attempt %d = %s, want between %s and %s", n+1, d, max/2, max)
+			}
+		}
+	}
+}
+
+func TestValidateRetryPolicy(t *testing.T) {
+	valid := map[string]string{
+		RetryAttemptsPropertyKey:   "3",
+		RetryExitCodesPropertyKey:  "1,137",
+		RetryBackoffPropertyKey:    "30s",
+		RetryMaxBackoffPropertyKey: "5m",
+	}
+	if err := ValidateTaskConfig(valid); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, config := range []map[string]string{
+		{RetryAttemptsPropertyKey: "0"},
+		{RetryInfraAttemptsPropertyKey: "-1"},
+		{RetryExitCodesPropertyKey: "1,x"},
+		{RetryExitCodesPropertyKey: "256"},
+		{RetryBackoffPropertyKey: "soon"},
+	} {
+		if err := ValidateTaskConfig(config); err == nil {
+			t.Errorf("%v should be rejected", config)
+		}
+	}
+}
@@ -1,144 +1,470 @@
 package service
 
 import (
-	"fmt"
-	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
-	"github.com/ufcg-lsd/arrebol-pb/docker"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/worker"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
 	"log"
-	"os"
-	"strconv"
 	"sync"
 	"time"
 )
 
 // no preemptive
 type Scheduler struct {
-	workers      []*Worker
-	pendingTasks chan *storage.Task
-	pendingPlans chan *AllocationPlan
-	policy       Policy
-	mutex        sync.Mutex
+	queueID uint
+	workers []*Worker
+	// policy orders the tasks waiting for a worker
+	policy SchedulingPolicy
+	// seq counts the tasks handed to the scheduler, telling the order they arrived
+	seq uint64
+	// nodes holds the state of the nodes the workers run on
+	nodes         map[uint]storage.ResourceState
+	wake          chan struct{}
+	cancelledJobs map[uint]bool
+	// jobTasks counts the tasks of each job the scheduler holds, be they waiting, running or
+	// due to be retried. A cancelled job is forgotten once it has none left.
+	jobTasks map[uint]int
+	// held schedulers run no tasks, and done is closed once the scheduler is stopped
+	held  bool
+	done  chan struct{}
+	mutex sync.Mutex
 }
 
-type Policy uint
-
+// TaskRetryTimeInterval is how often the waiting tasks are tried again when nothing wakes the scheduler
 const TaskRetryTimeInterval = 10 * time.Second
 
+// DockerWorkerSlotsKey and WorkerSlotsKey set how many tasks each docker or raw worker
+// runs at once, one by default
 const (
-	Fifo Policy = iota
+	DockerWorkerSlotsKey = "DOCKER_WORKER_SLOTS"
+	WorkerSlotsKey       = "WORKER_SLOTS"
 )
 
-func (p Policy) String() string {
-	return [...]string{"Fifo"}[p]
-}
-
-func (p Policy) schedule(plans chan *AllocationPlan) {
-	switch p {
-	case Fifo:
-		for plan := range plans {
-			go plan.execute()
-		}
-	default:
-		log.Println("Just support fifo")
-	}
-}
-
-func NewScheduler(policy Policy) *Scheduler {
+func NewScheduler(queueID uint, policy SchedulingPolicy) *Scheduler {
 	return &Scheduler{
-		policy:       policy,
-		workers:      make([]*Worker, 0),
-		pendingTasks: make(chan *storage.Task),
-		pendingPlans: make(chan *AllocationPlan),
+		queueID:       queueID,
+		policy:        policy,
+		workers:       make([]*Worker, 0),
+		nodes:         make(map[uint]storage.ResourceState),
+		wake:          make(chan struct{}, 1),
+		cancelledJobs: make(map[uint]bool),
+		jobTasks:      make(map[uint]int),
+		done:          make(chan struct{}),
 	}
 }
 
 func (s *Scheduler) Start() {
-	// only support raw workers, for now, meaning jobs sent to the supervisor of this scheduler will run
-	// uninsulated and on the Unix-type host operating system
 	s.HireWorkers()
-	go s.inferPlans()
 	s.Schedule()
 }
 
+// Schedule executes the waiting tasks, in the order of the policy, as workers become free.
+// It is woken up when a task arrives or a worker finishes one.
 func (s *Scheduler) Schedule() {
-	s.policy.schedule(s.pendingPlans)
+	ticker := time.NewTicker(TaskRetryTimeInterval)
+	defer ticker.Stop()
+	for {
+		s.updateNodes()
+		plans, held := s.inferPlans()
+		for _, plan := range plans {
+			go func(plan *AllocationPlan) {
+				plan.execute(s)
+				s.wakeUp()
+			}(plan)
+		}
+		s.explain(held)
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
 }
 
-// should be specific by node
+// HireWorkers hires the workers of every node of the queue
 func (s *Scheduler) HireWorkers() {
-	pool, _ := strconv.Atoi(os.Getenv("WORKERS_AMOUNT"))
-	if os.Getenv("DRIVER") == "docker" {
-		address := os.Getenv("WORKER_ADDRESS")
-		cli := docker.NewDockerClient(address)
-		for i := 0; i < pool; i++ {
-			_driver := driver.DockerDriver{
-				Id:  fmt.Sprintf("docker-worker-%d", i),
-				Cli: *cli,
-			}
-			s.workers = append(s.workers, NewWorker(&_driver))
+	nodes, err := storage.DB.RetrieveNodesByQueueID(s.queueID)
+	if err != nil {
+		log.Printf("Unable to retrieve the nodes of queue [%d]: %s", s.queueID, err.Error())
+		return
+	}
+	for _, node := range nodes {
+		if err := s.AddNode(node); err != nil {
+			log.Printf("Unable to hire the workers of node [%d]: %s", node.ID, err.Error())
+		}
+	}
+}
+
+// AddNode hires the workers of the node, unless they were already hired
+func (s *Scheduler) AddNode(node *storage.ResourceNode) error {
+	s.mutex.Lock()
+	_, ok := s.nodes[node.ID]
+	s.mutex.Unlock()
+	if ok {
+		return nil
+	}
+
+	workers, err := nodeWorkers(node)
+	if err != nil {
+		return err
+	}
+	for _, w := range workers {
+		if node.State == storage.Draining {
+			w.drain()
+		}
+	}
+
+	s.mutex.Lock()
+	if _, ok := s.nodes[node.ID]; ok {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.nodes[node.ID] = node.State
+	s.workers = append(s.workers, workers...)
+	s.mutex.Unlock()
+
+	log.Printf("Hired %d workers of node [%d] on queue [%d]", len(workers), node.ID, s.queueID)
+	s.wakeUp()
+	return nil
+}
+
+// DrainNode stops the workers of the node from taking new tasks. The node is removed once
+// the tasks they are running end.
+func (s *Scheduler) DrainNode(nodeID uint) {
+	s.mutex.Lock()
+	if _, ok := s.nodes[nodeID]; ok {
+		s.nodes[nodeID] = storage.Draining
+	}
+	for _, w := range s.workers {
+		if w.node == nodeID {
+			w.drain()
 		}
+	}
+	s.mutex.Unlock()
+
+	log.Printf("Draining node [%d] of queue [%d]", nodeID, s.queueID)
+	s.wakeUp()
+}
+
+// updateNodes records which nodes have workers running tasks, and removes the draining
+// nodes whose workers have none left
+func (s *Scheduler) updateNodes() {
+	s.mutex.Lock()
+	working := make(map[uint]bool)
+	retired := make(map[uint]bool)
+	for _, w := range s.workers {
+		if w.State() != Sleeping {
+			working[w.node] = true
+		}
+		if r, ok := retired[w.node]; !ok || r {
+			retired[w.node] = w.retired()
+		}
+	}
+
+	var removed []uint
+	changed := make(map[uint]storage.ResourceState)
+	workers := s.workers[:0]
+	for _, w := range s.workers {
+		if !retired[w.node] {
+			workers = append(workers, w)
+		}
+	}
+	s.workers = workers
+	for id, state := range s.nodes {
+		if retired[id] {
+			delete(s.nodes, id)
+			removed = append(removed, id)
+			continue
+		}
+		next := storage.Idle
+		if working[id] {
+			next = storage.Allocated
+		}
+		if state != storage.Draining && state != next {
+			s.nodes[id] = next
+			changed[id] = next
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, id := range removed {
+		log.Printf("Node [%d] of queue [%d] drained, removing it", id, s.queueID)
+		if err := storage.DB.DeleteNode(id); err != nil {
+			log.Printf("Unable to remove node [%d]: %s", id, err.Error())
+		}
+	}
+	for id, state := range changed {
+		if err := storage.DB.SetNodeState(id, state); err != nil {
+			log.Printf("Unable to update the state of node [%d]: %s", id, err.Error())
+		}
+	}
+}
+
+// AddTask hands a task to wait for a worker, taking the priority of its job unless it declares its own
+func (s *Scheduler) AddTask(task *storage.Task, jobPriority int) {
+	s.mutex.Lock()
+	s.seq++
+	s.jobTasks[task.JobID]++
+	pending := &PendingTask{Task: task, Seq: s.seq, Priority: taskPriority(task, jobPriority)}
+	s.mutex.Unlock()
+
+	// a task resumed while it waited to be retried keeps waiting
+	if task.RetryAt != nil && time.Until(*task.RetryAt) > 0 {
+		time.AfterFunc(time.Until(*task.RetryAt), func() { s.requeue(pending) })
+		return
+	}
+	s.requeue(pending)
+}
+
+// requeue hands back a task that waited to be retried, keeping its place
+func (s *Scheduler) requeue(pending *PendingTask) {
+	s.mutex.Lock()
+	if jobID := pending.Task.JobID; s.cancelledJobs[jobID] {
+		log.Printf("Dropping task [%d] of a cancelled job", pending.Task.ID)
+		s.forget(jobID, 1)
 	} else {
-		_driver := driver.RawDriver{}
-		for i := 0; i < pool; i++ {
-			s.workers = append(s.workers, NewWorker(&_driver))
+		s.policy.Push(pending)
+	}
+	s.mutex.Unlock()
+	s.wakeUp()
+}
+
+// finish stops counting a task the scheduler is done with
+func (s *Scheduler) finish(task *storage.Task) {
+	s.mutex.Lock()
+	s.forget(task.JobID, 1)
+	s.mutex.Unlock()
+}
+
+// forget stops counting n tasks of the job, forgetting the job once none is left. It must be
+// called holding the mutex.
+func (s *Scheduler) forget(jobID uint, n int) {
+	if s.jobTasks[jobID] -= n; s.jobTasks[jobID] <= 0 {
+		delete(s.jobTasks, jobID)
+		delete(s.cancelledJobs, jobID)
+	}
+}
+
+// Reprioritize changes the priority of the waiting tasks of the job, except the ones declaring their own
+func (s *Scheduler) Reprioritize(jobID uint, priority int) {
+	s.mutex.Lock()
+	var waiting []*PendingTask
+	for t := s.policy.Pop(); t != nil; t = s.policy.Pop() {
+		if t.Task.JobID == jobID {
+			t.Priority = taskPriority(t.Task, priority)
 		}
+		waiting = append(waiting, t)
 	}
-	//log.Println("just support system level execution with static pool of workers")
+	for _, t := range waiting {
+		s.policy.Push(t)
+	}
+	s.mutex.Unlock()
+	s.wakeUp()
+}
+
+// Policy is the name of the policy ordering the waiting tasks
+func (s *Scheduler) Policy() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.policy.Name()
+}
+
+// SetPolicy replaces the policy, handing it the tasks waiting on the current one
+func (s *Scheduler) SetPolicy(policy SchedulingPolicy) {
+	s.mutex.Lock()
+	for t := s.policy.Pop(); t != nil; t = s.policy.Pop() {
+		policy.Push(t)
+	}
+	log.Printf("Scheduling policy changed from [%s] to [%s]", s.policy.Name(), policy.Name())
+	s.policy = policy
+	s.mutex.Unlock()
+	s.wakeUp()
+}
+
+// Hold stops the scheduler from running the waiting tasks, or lets it run them again
+func (s *Scheduler) Hold(held bool) {
+	s.mutex.Lock()
+	s.held = held
+	s.mutex.Unlock()
+	s.wakeUp()
 }
 
-func (s *Scheduler) AddTask(task *storage.Task) {
-	s.pendingTasks <- task
+// Stop ends the scheduling loop, leaving the tasks being executed to end on their own
+func (s *Scheduler) Stop() {
+	close(s.done)
+}
+
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// the scheduler is already due to wake up
+	}
+}
+
+// CancelJob drops the pending tasks of the job and aborts the ones being executed
+func (s *Scheduler) CancelJob(jobID uint) {
+	s.mutex.Lock()
+	s.cancelledJobs[jobID] = true
+	removed := 0
+	s.policy.Remove(func(task *storage.Task) bool {
+		if task.JobID != jobID {
+			return false
+		}
+		removed++
+		return true
+	})
+	s.forget(jobID, removed)
+	workers := s.workers
+	s.mutex.Unlock()
+
+	for _, worker := range workers {
+		worker.AbortJob(jobID)
+	}
 }
 
 type AllocationPlan struct {
-	task   *storage.Task
-	worker *Worker
+	task    *storage.Task
+	worker  *Worker
+	pending *PendingTask
 }
 
-func (a *AllocationPlan) execute() {
-	a.worker.Execute(a.task)
+// execute runs the task, handing it back to wait once it is due to be retried
+func (a *AllocationPlan) execute(s *Scheduler) {
+	if wait, retry := a.worker.Execute(a.task); retry {
+		time.AfterFunc(wait, func() { s.requeue(a.pending) })
+	} else {
+		s.finish(a.task)
+	}
 }
 
-// Seeding to the channel of plans.
-// Listening to the channel of pending tasks.
-// Ever that a new task exists this method will be called
-// generating a new resource allocation plan to execute the task
-func (s *Scheduler) inferPlans() {
-	for {
-		task := <-s.pendingTasks
-		log.Printf("Planning to run task [%d]", task.ID)
+// heldTask is a task left waiting, along with why no local worker could ever run it
+type heldTask struct {
+	task   *storage.Task
+	reason string
+}
 
-		plan := s.inferPlanForTask(task)
+// inferPlans takes the waiting tasks in the order of the policy, generating a resource
+// allocation plan for each one a worker is free to execute. The tasks no worker
+// matches go back to wait, keeping their place, and are returned as held.
+func (s *Scheduler) inferPlans() ([]*AllocationPlan, []heldTask) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-		if plan != nil {
-			s.pendingPlans <- plan // a channel is used here because only fifo's policy is supported
+	var plans []*AllocationPlan
+	var held []heldTask
+	var unmatched []*PendingTask
+	if s.held {
+		return plans, held
+	}
+	for s.policy.Len() > 0 && s.hasFreeWorker() {
+		pending := s.policy.Pop()
+		task := pending.Task
+		if s.cancelledJobs[task.JobID] {
+			log.Printf("Dropping task [%d] of a cancelled job", task.ID)
+			s.forget(task.JobID, 1)
+			continue
+		}
+		log.Printf("Planning to run task [%d]", task.ID)
+		if plan := s.inferPlanForTask(task); plan != nil {
+			plan.pending = pending
+			plans = append(plans, plan)
 		} else {
-			go func() {
-				time.Sleep(TaskRetryTimeInterval)
-				s.pendingTasks <- task
-				log.Printf("Retring the task [%d]", task.ID)
-			}()
+			unmatched = append(unmatched, pending)
+			held = append(held, heldTask{task: task, reason: s.unschedulable(task)})
+		}
+	}
+	for _, pending := range unmatched {
+		s.policy.Push(pending)
+	}
+	return plans, held
+}
+
+// unschedulable tells why no local worker could ever run the task, empty when one could
+func (s *Scheduler) unschedulable(task *storage.Task) string {
+	reason := ""
+	for _, worker := range s.workers {
+		if !worker.accepting() {
+			continue
+		}
+		if reason = worker.unfit(task); reason == "" {
+			return ""
+		}
+	}
+	return reason
+}
+
+// explain records on the held tasks why they can not be scheduled, unless an alive remote
+// worker of the queue could lease them, and clears it from the ones that now could be
+func (s *Scheduler) explain(held []heldTask) {
+	var remote []*worker.Worker
+	fetched := false
+	for _, h := range held {
+		reason := h.reason
+		if reason != "" && !fetched {
+			var err error
+			if remote, err = storage.DB.RetrieveWorkersByQueueID(s.queueID); err != nil {
+				log.Printf("Unable to retrieve the workers of queue [%d]: %s", s.queueID, err.Error())
+				return
+			}
+			fetched = true
+		}
+		if reason != "" {
+			for _, w := range remote {
+				if w.State == worker.Alive && unfit(h.task, RemoteCapacity(w), w.Labels) == "" {
+					reason = ""
+					break
+				}
+			}
 		}
+		if h.task.Reason == reason {
+			continue
+		}
+		h.task.Reason = reason
+		if err := storage.DB.SetTaskReason(h.task.ID, reason); err != nil {
+			log.Printf("Unable to record why task [%d] is held: %s", h.task.ID, err.Error())
+			continue
+		}
+		if reason != "" {
+			log.Printf("Task [%d] is unschedulable: %s", h.task.ID, reason)
+		}
+		events.TaskChanged(h.task)
 	}
+}
 
+func (s *Scheduler) hasFreeWorker() bool {
+	for _, worker := range s.workers {
+		if worker.accepting() && worker.State() != Busy {
+			return true
+		}
+	}
+	return false
 }
 
+// inferPlanForTask places the task on the worker it fits the tightest, so the larger
+// free capacities are kept for the tasks that need them. It must be called holding the
+// mutex of the scheduler.
 func (s *Scheduler) inferPlanForTask(task *storage.Task) *AllocationPlan {
-	s.mutex.Lock()
 	log.Printf("Searching worker for task [%d]", task.ID)
+	var best *Worker
+	var bestFit float64
 	for _, worker := range s.workers {
-		if worker.MatchAny(task) {
-			log.Printf("The task [%d] matched with the worker [%s]", task.ID, worker.id)
-			return s.makePlan(worker, task)
+		if !worker.MatchAny(task) {
+			continue
+		}
+		if fit := worker.fit(task); best == nil || fit < bestFit {
+			best, bestFit = worker, fit
 		}
 	}
-	defer s.mutex.Unlock()
-	return nil
+	if best == nil || !best.reserve(task) {
+		return nil
+	}
+	log.Printf("The task [%d] matched with the worker [%s]", task.ID, best.id)
+	return s.makePlan(best, task)
 }
 
 func (s *Scheduler) makePlan(w *Worker, t *storage.Task) *AllocationPlan {
-	w.state = Busy
 	// TODO Change task state to pending or queued
 	return &AllocationPlan{
 		task:   t,
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
+	"reflect"
+	"testing"
+)
+
+func TestSchedulerReprioritize(t *testing.T) {
+	policy, _ := NewPolicy(PriorityPolicy)
+	s := NewScheduler(1, policy)
+	s.AddTask(newTask(1, 1, nil), 0)
+	s.AddTask(newTask(2, 2, nil), 0)
+	s.AddTask(newTask(3, 2, map[string]string{PriorityPropertyKey: "-5"}), 0)
+	s.AddTask(newTask(4, 3, nil), 1)
+
+	// the job overtakes the others, except for the task declaring its own priority
+	s.Reprioritize(2, 10)
+
+	var ids []uint
+	for p := s.policy.Pop(); p != nil; p = s.policy.Pop() {
+		ids = append(ids, p.Task.ID)
+	}
+	if want := []uint{2, 4, 1, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("order = %v, want %v", ids, want)
+	}
+}
+
+func TestSchedulerHold(t *testing.T) {
+	policy, _ := NewPolicy(FifoPolicy)
+	s := NewScheduler(1, policy)
+	s.workers = append(s.workers, NewWorker(&driver.RawDriver{}, 1, Resources{}, nil))
+	s.AddTask(newTask(1, 1, nil), 0)
+
+	s.Hold(true)
+	if plans, _ := s.inferPlans(); len(plans) != 0 || s.policy.Len() != 1 {
+		t.Fatalf("a held scheduler planned %d tasks, want none left waiting", len(plans))
+	}
+	s.Hold(false)
+	if plans, _ := s.inferPlans(); len(plans) != 1 {
+		t.Errorf("planned %d tasks, want 1", len(plans))
+	}
+}
+
+func TestSchedulerForgetsDrainedCancelledJob(t *testing.T) {
+	policy, _ := NewPolicy(FifoPolicy)
+	s := NewScheduler(1, policy)
+	s.workers = append(s.workers, NewWorker(&driver.RawDriver{}, 1, Resources{}, nil))
+	s.AddTask(newTask(1, 1, nil), 0)
+	s.AddTask(newTask(2, 1, nil), 0)
+
+	plans, _ := s.inferPlans()
+	if len(plans) != 1 {
+		t.Fatalf("planned %d tasks, want 1", len(plans))
+	}
+	s.CancelJob(1)
+	if !s.cancelledJobs[1] || s.policy.Len() != 0 {
+		t.Fatal("the job should stay cancelled while its task runs, with no task left waiting")
+	}
+
+	// the running task drains, leaving nothing of the job behind
+	s.finish(plans[0].task)
+	if len(s.cancelledJobs) != 0 || len(s.jobTasks) != 0 {
+		t.Errorf("cancelled jobs = %v and job tasks = %v, want both empty", s.cancelledJobs, s.jobTasks)
+	}
+}
@@ -1,23 +1,47 @@
 package service
 
 import (
+	"fmt"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
 	"log"
 	"sync"
 	"time"
 )
 
+// MonitorPollingPeriod is how often the state of a job is checked when none of its tasks changes
+const MonitorPollingPeriod = 30 * time.Second
+
+// MonitorSettlingPeriod is how long the events of a job are gathered before its state is checked,
+// so that a burst of them, such as the tasks of a large array finishing, is handled at once
+const MonitorSettlingPeriod = 500 * time.Millisecond
+
 type Supervisor struct {
 	queue     *storage.Queue
 	scheduler *Scheduler
-	mux       sync.Mutex
+	// paused supervisors hand no tasks to the scheduler, resumed waking the monitors once
+	// they are unpaused
+	paused  bool
+	resumed chan struct{}
+	// done is closed once the supervisor is fired
+	done chan struct{}
+	mux  sync.Mutex
 }
 
 func NewSupervisor(queue *storage.Queue) *Supervisor {
-	return &Supervisor{
+	policy, err := NewPolicy(queue.Policy)
+	if err != nil {
+		log.Printf("Queue [%d] has the unknown policy [%s], using [%s]", queue.ID, queue.Policy, DefaultPolicy)
+		policy, _ = NewPolicy(DefaultPolicy)
+	}
+	s := &Supervisor{
 		queue:     queue,
-		scheduler: NewScheduler(Fifo),
+		scheduler: NewScheduler(queue.ID, policy),
+		resumed:   make(chan struct{}),
+		done:      make(chan struct{}),
 	}
+	s.SetState(queue.State)
+	return s
 }
 
 // Starts the supervisor protocol with a static default scheduler
@@ -27,38 +51,218 @@ func (s *Supervisor) Start() {
 	s.pokeScheduler()
 }
 
+// SetState pauses the supervisor when the queue is paused, resuming it otherwise
+func (s *Supervisor) SetState(state storage.QueueState) {
+	paused := state == storage.QueuePaused
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.paused == paused {
+		return
+	}
+	s.paused = paused
+	s.scheduler.Hold(paused)
+	if !paused {
+		close(s.resumed)
+		s.resumed = make(chan struct{})
+	}
+	log.Printf("Supervisor of queue [%d] is now %s", s.queue.ID, state)
+}
+
+// waitResume returns whether the supervisor is paused, along with a channel closed once it is resumed
+func (s *Supervisor) waitResume() (bool, chan struct{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.paused, s.resumed
+}
+
+// Fire stops the supervisor, its scheduler and the monitors of its jobs
+func (s *Supervisor) Fire() {
+	log.Printf("Firing the supervisor of queue [%d]", s.queue.ID)
+	close(s.done)
+	s.scheduler.Stop()
+}
+
 func (s *Supervisor) Collect(job *storage.Job) {
 	log.Printf("Collecting tasks of the job %d", job.ID)
-	tasks := &job.Tasks
-	for _, task := range *tasks {
-		task.State = storage.TaskPending
-		s.scheduler.AddTask(task)
-	}
 	go s.jobStateMonitor(job.ID)
 }
 
+// Resume hands to the scheduler the pending tasks of a job accepted before a restart
+func (s *Supervisor) Resume(job *storage.Job) {
+	log.Printf("Resuming the job %d", job.ID)
+	go s.jobStateMonitor(job.ID)
+}
+
+// advance hands to the scheduler the pending tasks of the job it was not handed yet, along
+// with the waiting ones whose dependencies have completed, and skips the ones that will never run
+func (s *Supervisor) advance(job *storage.Job, submitted map[uint]bool) {
+	onUpstreamFailure := job.OnUpstreamFailure
+	if onUpstreamFailure == "" {
+		onUpstreamFailure = DefaultUpstreamFailure
+	}
+	bySpec := make(map[string]*storage.Task)
+	var failed *storage.Task
+	paused, _ := s.waitResume()
+	for _, task := range job.Tasks {
+		if task.SpecID != "" {
+			bySpec[task.SpecID] = task
+		}
+		if task.State == storage.TaskFailed && failed == nil {
+			failed = task
+		}
+	}
+
+	// a task skipped or unblocked may decide on the ones depending on it, so the tasks are
+	// gone through until none changes
+	for changed := true; changed; {
+		changed = false
+		for _, task := range job.Tasks {
+			if task.State != storage.TaskPending && task.State != storage.TaskWaiting {
+				continue
+			}
+			if onUpstreamFailure == FailJob && failed != nil {
+				changed = s.skip(task, fmt.Sprintf("the job failed with task [%d]", failed.ID)) || changed
+				continue
+			}
+			if task.State == storage.TaskPending {
+				if !paused && !submitted[task.ID] {
+					submitted[task.ID] = true
+					// the scheduler gets a copy, since its workers change the task as they run it
+					pending := *task
+					s.scheduler.AddTask(&pending, job.Priority)
+				}
+				continue
+			}
+			switch v, reason := judge(task, bySpec, onUpstreamFailure); v {
+			case unblock:
+				changed = s.unblock(task) || changed
+			case skip:
+				changed = s.skip(task, reason) || changed
+			}
+		}
+	}
+}
+
+func (s *Supervisor) unblock(task *storage.Task) bool {
+	unblocked, err := storage.DB.UnblockTask(task.ID)
+	if err != nil {
+		log.Printf("Unable to unblock task [%d]: %s", task.ID, err.Error())
+	}
+	if !unblocked {
+		return false
+	}
+	log.Printf("The dependencies of task [%d] have completed", task.ID)
+	task.State = storage.TaskPending
+	task.Reason = ""
+	events.TaskChanged(task)
+	return true
+}
+
+func (s *Supervisor) skip(task *storage.Task, reason string) bool {
+	skipped, err := storage.DB.SkipTask(task.ID, reason)
+	if err != nil {
+		log.Printf("Unable to skip task [%d]: %s", task.ID, err.Error())
+	}
+	if !skipped {
+		return false
+	}
+	log.Printf("Skipped task [%d]: %s", task.ID, reason)
+	task.State = storage.TaskSkipped
+	task.Reason = reason
+	events.TaskChanged(task)
+	return true
+}
+
+// jobStateMonitor releases the tasks of the job as their dependencies complete and keeps the
+// state of the job up to date with the states of its tasks. It is woken up by the events of
+// the job, polling from time to time in case one is missed.
 func (s *Supervisor) jobStateMonitor(jobId uint) {
+	// the output lines of the commands never change the state of the job
+	kinds := []events.Kind{events.JobKind, events.TaskKind}
+	var lastID uint64
+	_, sub := events.DefaultBus.SubscribeKinds(jobId, lastID, kinds...)
+	defer func() { events.DefaultBus.Unsubscribe(sub) }()
+	ticker := time.NewTicker(MonitorPollingPeriod)
+	defer ticker.Stop()
+	submitted := make(map[uint]bool)
+
 	for {
-		job, _ := storage.DB.RetrieveJobByQueue(jobId, s.queue.ID)
-		js := s.getJobState(*job)
-		if job.State != js {
-			storage.DB.SetJobState(job.ID, js)
-			log.Printf("Updated Job [%d] to state [%s]", jobId, js.String())
+		job, err := storage.DB.RetrieveJobByQueue(jobId, s.queue.ID)
+		if err != nil {
+			log.Printf("Unable to monitor the job [%d]: %s", jobId, err.Error())
+		} else {
+			if job.State == storage.JobCancelled {
+				break
+			}
+			s.advance(job, submitted)
+			js := s.getJobState(*job)
+			if job.State != js {
+				storage.DB.SetJobState(job.ID, js)
+				events.JobChanged(job.ID, js)
+				log.Printf("Updated Job [%d] to state [%s]", jobId, js.String())
+			}
+			if js == storage.JobFinished || js == storage.JobFailed {
+				break
+			}
 		}
-		if job.State == storage.JobFinished || job.State == storage.JobFailed {
-			break
+
+		_, resumed := s.waitResume()
+	wait:
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-resumed:
+				break wait
+			case e, ok := <-sub.C:
+				if ok {
+					lastID = e.ID
+					lastID, ok = settle(sub, lastID, MonitorSettlingPeriod, s.done)
+				}
+				if !ok {
+					// the subscription was dropped for falling behind, so the state is checked again
+					_, sub = events.DefaultBus.SubscribeKinds(jobId, lastID, kinds...)
+				}
+				break wait
+			case <-ticker.C:
+				break wait
+			}
 		}
-		time.Sleep(3 * time.Second)
 	}
 }
 
+// settle consumes the events of the subscription arriving within the period, returning the ID
+// of the last one and false when the subscription was dropped meanwhile
+func settle(sub *events.Subscription, lastID uint64, period time.Duration, done <-chan struct{}) (uint64, bool) {
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return lastID, false
+			}
+			lastID = e.ID
+		case <-timer.C:
+			return lastID, true
+		case <-done:
+			return lastID, true
+		}
+	}
+}
+
+// getJobState rolls the states of the tasks up into the one of the job. A job none of whose
+// tasks finished has failed, as has one failing on upstream failure with a task that failed.
 func (s *Supervisor) getJobState(job storage.Job) storage.JobState {
 	var jobState storage.JobState
-	if s.isAll([]storage.TaskState{storage.TaskFailed}, job.Tasks) {
+	if s.isAll([]storage.TaskState{storage.TaskFailed, storage.TaskSkipped}, job.Tasks) {
 		jobState = storage.JobFailed
-	} else if s.isAll([]storage.TaskState{storage.TaskFailed, storage.TaskFinished}, job.Tasks) {
+	} else if s.isAll([]storage.TaskState{storage.TaskFailed, storage.TaskFinished, storage.TaskSkipped}, job.Tasks) {
 		jobState = storage.JobFinished
-	} else if s.isAll([]storage.TaskState{storage.TaskPending}, job.Tasks) {
+		if job.OnUpstreamFailure == FailJob && !s.isAll([]storage.TaskState{storage.TaskFinished, storage.TaskSkipped}, job.Tasks) {
+			jobState = storage.JobFailed
+		}
+	} else if s.isAll([]storage.TaskState{storage.TaskPending, storage.TaskWaiting}, job.Tasks) {
 		jobState = storage.JobQueued
 	} else {
 		jobState = storage.JobRunning
@@ -84,6 +288,12 @@ func contains(e storage.TaskState, arr []storage.TaskState) bool {
 	return false
 }
 
+// Cancel stops the execution of a job whose cancellation was already persisted
+func (s *Supervisor) Cancel(jobID uint) {
+	log.Printf("Cancelling the job %d", jobID)
+	s.scheduler.CancelJob(jobID)
+}
+
 func (s *Supervisor) pokeScheduler() {
 	log.Println("Scheduler woke up")
 	s.scheduler.Start()
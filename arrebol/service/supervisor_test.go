@@ -0,0 +1,54 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"testing"
+	"time"
+)
+
+func TestSupervisorSetState(t *testing.T) {
+	s := NewSupervisor(&storage.Queue{State: storage.QueuePaused})
+	paused, resumed := s.waitResume()
+	if !paused || !s.scheduler.held {
+		t.Fatal("the supervisor of a paused queue should hold its scheduler")
+	}
+
+	s.SetState(storage.QueueDraining)
+	select {
+	case <-resumed:
+	default:
+		t.Fatal("the monitors should be woken once the supervisor is resumed")
+	}
+	if paused, _ = s.waitResume(); paused || s.scheduler.held {
+		t.Error("a draining queue should keep running its tasks")
+	}
+
+	for name, want := range map[string]storage.QueueState{"open": storage.QueueOpen, "Paused": storage.QueuePaused, "DRAINING": storage.QueueDraining} {
+		if state, err := ParseQueueState(name); err != nil || state != want {
+			t.Errorf("ParseQueueState(%q) = %s, %v, want %s", name, state, err, want)
+		}
+	}
+	if _, err := ParseQueueState("closed"); err != UnknownStateErr {
+		t.Errorf("expected an unknown state error, got %v", err)
+	}
+}
+
+func TestSettleGathersBurst(t *testing.T) {
+	bus := events.NewBus(0, 0)
+	_, sub := bus.SubscribeKinds(1, 0, events.TaskKind)
+	defer bus.Unsubscribe(sub)
+	for i := 0; i < 100; i++ {
+		bus.Publish(events.Event{Kind: events.TaskKind, JobID: 1})
+	}
+
+	lastID, ok := settle(sub, 0, 20*time.Millisecond, make(chan struct{}))
+	if !ok || lastID != 100 {
+		t.Errorf("settled on %d, %v; want the whole burst up to the event 100", lastID, ok)
+	}
+
+	bus.Unsubscribe(sub)
+	if _, ok = settle(sub, lastID, time.Minute, make(chan struct{})); ok {
+		t.Error("a dropped subscription was not reported")
+	}
+}
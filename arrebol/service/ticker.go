@@ -0,0 +1,147 @@
+package service
+
+import (
+	"fmt"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/cron"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"log"
+	"time"
+)
+
+// The behaviours a schedule may have when it fires while the last job it submitted has not completed
+const (
+	// OverlapSkip submits no job, recording the firing as skipped
+	OverlapSkip = "skip"
+	// OverlapAllow submits the job, leaving the last one running
+	OverlapAllow = "allow"
+	// OverlapCancel cancels the last job before submitting the new one
+	OverlapCancel  = "cancel"
+	DefaultOverlap = OverlapSkip
+)
+
+// TickingPeriodTime is how often the ticker looks for the schedules due to fire
+const TickingPeriodTime = 15 * time.Second
+
+var UnknownOverlapErr = errors.BadRequest.New("unknown overlap policy, use one of skip, allow or cancel")
+
+// ValidOverlap tells if a schedule may have the overlap policy
+func ValidOverlap(name string) bool {
+	switch name {
+	case OverlapSkip, OverlapAllow, OverlapCancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// NextRun is the first time after t the cron expression fires, in the timezone, UTC when
+// it is empty. It fails when the expression or the timezone is malformed, or when the
+// expression never fires.
+func NextRun(expr, timezone string, t time.Time) (time.Time, error) {
+	e, err := cron.Parse(expr)
+	if err != nil {
+		return time.Time{}, errors.BadRequest.Newf("Malformed cron expression [%s]: %s", expr, err.Error())
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, errors.BadRequest.Newf("Unknown timezone [%s]", timezone)
+	}
+	next := e.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.BadRequest.Newf("The cron expression [%s] never fires", expr)
+	}
+	return next, nil
+}
+
+// SubmitFunc creates the job of a schedule the way the jobs sent to the api are created,
+// handing it to the dispatcher
+type SubmitFunc func(schedule *storage.Schedule) (*storage.Job, error)
+
+// The Ticker fires the schedules of every queue, submitting their jobs as their cron
+// expressions tell. A schedule due while the server was down fires once it is back.
+type Ticker struct {
+	dispatcher *Dispatcher
+	submit     SubmitFunc
+}
+
+func NewTicker(dispatcher *Dispatcher, submit SubmitFunc) *Ticker {
+	return &Ticker{
+		dispatcher: dispatcher,
+		submit:     submit,
+	}
+}
+
+func (t *Ticker) Start() {
+	log.Println("Ticker started")
+	for now := range time.Tick(TickingPeriodTime) {
+		t.tick(now)
+	}
+}
+
+func (t *Ticker) tick(now time.Time) {
+	schedules, err := storage.DB.RetrieveDueSchedules(now)
+	if err != nil {
+		log.Printf("Error while retrieving the schedules due to fire: %s", err.Error())
+		return
+	}
+	for _, schedule := range schedules {
+		t.fire(schedule, now)
+	}
+}
+
+// fire submits the job of the schedule, minding its overlap policy, and records the firing
+func (t *Ticker) fire(schedule *storage.Schedule, now time.Time) {
+	due := *schedule.NextRunAt
+	var next *time.Time
+	if n, err := NextRun(schedule.Cron, schedule.Timezone, now); err == nil {
+		next = &n
+	} else {
+		log.Printf("Schedule [%d] will not fire again: %s", schedule.ID, err.Error())
+	}
+	if advanced, err := storage.DB.AdvanceSchedule(schedule.ID, due, next); !advanced || err != nil {
+		// the schedule was changed since it was retrieved, it is left as it is
+		return
+	}
+
+	run := &storage.ScheduleRun{ScheduleID: schedule.ID, FiredAt: due}
+	if reason := t.overlap(schedule); reason != "" {
+		run.Outcome = storage.RunSkipped
+		run.Reason = reason
+	} else if job, err := t.submit(schedule); err != nil {
+		run.Outcome = storage.RunFailed
+		run.Reason = err.Error()
+	} else {
+		run.Outcome = storage.RunSubmitted
+		run.JobID = &job.ID
+	}
+	log.Printf("Schedule [%d] fired, the run was %s", schedule.ID, run.Outcome)
+	if err := storage.DB.SaveScheduleRun(run); err != nil {
+		log.Printf("Unable to record the run of schedule [%d]: %s", schedule.ID, err.Error())
+	}
+}
+
+// overlap deals with the last job of the schedule if it has not completed, telling why no
+// job is submitted when the policy says so
+func (t *Ticker) overlap(schedule *storage.Schedule) string {
+	last, err := storage.DB.RetrieveLastScheduledJob(schedule.ID)
+	if err != nil {
+		log.Printf("Unable to retrieve the last job of schedule [%d]: %s", schedule.ID, err.Error())
+		return ""
+	}
+	if last == nil || last.State.IsCompleted() {
+		return ""
+	}
+
+	switch schedule.Overlap {
+	case OverlapAllow:
+		return ""
+	case OverlapCancel:
+		if err = t.dispatcher.CancelJob(last); err != nil && err != JobCompletedErr {
+			return fmt.Sprintf("unable to cancel the last job [%d]: %s", last.ID, err.Error())
+		}
+		return ""
+	default:
+		return fmt.Sprintf("the last job [%d] has not completed", last.ID)
+	}
+}
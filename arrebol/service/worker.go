@@ -1,15 +1,38 @@
 package service
 
 import (
+	"context"
 	"github.com/hashicorp/go-uuid"
 	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/events"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"log"
+	"sync"
+	"time"
 )
 
+// Worker executes tasks through its driver, as many at once as it has slots and
+// as its capacity holds
 type Worker struct {
-	id     string
-	driver driver.Driver
-	state  WorkerState
+	id       string
+	driver   driver.Driver
+	slots    int
+	capacity Resources
+	labels   map[string]string
+	// node is the node the worker runs on, draining when it takes no new tasks
+	node     uint
+	draining bool
+	// used counts the slots taken by planned and running tasks, and allocated the resources they hold
+	used      int
+	allocated Resources
+	reserved  map[uint]Resources
+	running   map[uint]*execution
+	mux       sync.Mutex
+}
+
+type execution struct {
+	task   *storage.Task
+	cancel context.CancelFunc
 }
 
 type WorkerState uint
@@ -20,24 +43,184 @@ const (
 	Busy
 )
 
-func NewWorker(driver2 driver.Driver) *Worker {
+func NewWorker(driver2 driver.Driver, slots int, capacity Resources, labels map[string]string) *Worker {
 	id, _ := uuid.GenerateUUID()
+	if slots < 1 {
+		slots = 1
+	}
 	return &Worker{
-		id:     id,
-		driver: driver2,
-		state:  Sleeping,
+		id:       id,
+		driver:   driver2,
+		slots:    slots,
+		capacity: capacity,
+		labels:   labels,
+		reserved: make(map[uint]Resources),
+		running:  make(map[uint]*execution),
+	}
+}
+
+// State tells if the worker is idle, running tasks with free slots left, or full
+func (w *Worker) State() WorkerState {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	switch w.used {
+	case 0:
+		return Sleeping
+	case w.slots:
+		return Busy
+	default:
+		return Working
 	}
 }
 
+// MatchAny tells if the worker has the labels the task asks for, a free slot and enough
+// free resources for it
 func (w *Worker) MatchAny(task *storage.Task) bool {
-	return w.state == Sleeping
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return !w.draining && w.used < w.slots && w.capacity.Fits(TaskRequirements(task), w.allocated) && MatchesLabels(task, w.labels)
+}
+
+// drain stops the worker from taking new tasks, letting the running ones end
+func (w *Worker) drain() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.draining = true
+}
+
+// accepting tells if the worker takes new tasks, which it does until it is drained
+func (w *Worker) accepting() bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return !w.draining
 }
 
-func (w *Worker) Execute(task *storage.Task) {
-	w.state = Working
+// retired tells if the worker is draining and has no tasks left
+func (w *Worker) retired() bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.draining && w.used == 0
+}
+
+// unfit tells why the worker could never run the task, empty when it could once it is idle
+func (w *Worker) unfit(task *storage.Task) string {
+	return unfit(task, w.capacity, w.labels)
+}
+
+// fit is how much of the capacity of the worker would be left free by the task, lower is tighter
+func (w *Worker) fit(task *storage.Task) float64 {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.capacity.leftover(TaskRequirements(task), w.allocated)
+}
+
+// reserve takes a slot and the resources for a task about to be executed, telling if they were free
+func (w *Worker) reserve(task *storage.Task) bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	requirement := TaskRequirements(task)
+	if w.draining || w.used == w.slots || !w.capacity.Fits(requirement, w.allocated) {
+		return false
+	}
+	w.used++
+	w.allocated = w.allocated.Add(requirement)
+	w.reserved[task.ID] = requirement
+	return true
+}
+
+func (w *Worker) release(task *storage.Task) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	delete(w.running, task.ID)
+	w.allocated = w.allocated.sub(w.reserved[task.ID])
+	delete(w.reserved, task.ID)
+	w.used--
+}
+
+// Execute runs a task on a slot previously reserved, releasing it at the end. A failed task
+// that may be attempted again goes back to pending, and the wait before it is returned.
+func (w *Worker) Execute(task *storage.Task) (time.Duration, bool) {
+	// the task may have been leased by a remote worker while it waited to be scheduled
+	if claimed, err := storage.DB.ClaimTask(task.ID, w.id); !claimed || err != nil {
+		log.Printf("Task [%d] was already taken, worker [%s] skipped it", task.ID, w.id)
+		w.release(task)
+		return 0, false
+	}
 	task.State = storage.TaskRunning
+	task.WorkerID = w.id
+	task.Reason = ""
+	task.RetryAt = nil
+	events.TaskChanged(task)
+
+	attempt, err := storage.DB.StartAttempt(task.ID, w.id)
+	if err != nil {
+		log.Printf("Unable to record the attempt of task [%d]: %s", task.ID, err.Error())
+	}
+
+	ctx, cancel := driver.TaskContext(context.Background(), task)
+	w.track(task, cancel)
+	if err := w.driver.Execute(ctx, task); err != nil {
+		log.Printf("Error while executing task [%d]: %s", task.ID, err.Error())
+		if task.State == storage.TaskRunning {
+			task.State = storage.TaskFailed
+		}
+		// the driver fails on its own only when the worker could not run the commands
+		if attempt != nil && ctx.Err() == nil {
+			attempt.Infrastructure = true
+			attempt.Error = err.Error()
+		}
+	}
+	w.release(task)
+	cancel()
+
+	if attempt != nil {
+		if err := storage.DB.EndAttempt(attempt, task); err != nil {
+			log.Printf("Unable to record the end of the attempt of task [%d]: %s", task.ID, err.Error())
+		}
+		task.Attempts = append(task.Attempts, attempt)
+		if wait, ok := TaskRetryPolicy(task).Next(task.Attempts); ok && w.retry(task, wait) {
+			return wait, true
+		}
+	}
 	_ = storage.DB.SaveTask(task)
-	w.driver.Execute(task)
-	_ = storage.DB.SaveTask(task)
-	w.state = Sleeping
+	events.TaskChanged(task)
+	return 0, false
+}
+
+// retry gives the failed task back to the queue, telling if it could
+func (w *Worker) retry(task *storage.Task, wait time.Duration) bool {
+	retryAt := time.Now().Add(wait)
+	if err := storage.DB.RetryTask(task.ID, w.id, retryAt); err != nil {
+		log.Printf("Unable to retry task [%d]: %s", task.ID, err.Error())
+		return false
+	}
+	task.State = storage.TaskPending
+	task.WorkerID = ""
+	task.RetryAt = &retryAt
+	for _, cmd := range task.Commands {
+		cmd.State = storage.CmdNotStarted
+		cmd.ExitCode = driver.NoExitCode
+	}
+	log.Printf("Task [%d] failed its attempt %d, retrying in %s", task.ID, len(task.Attempts), wait)
+	events.TaskChanged(task)
+	return true
+}
+
+// AbortJob aborts the tasks being executed by the worker that belong to the job
+func (w *Worker) AbortJob(jobID uint) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	for _, e := range w.running {
+		if e.task.JobID == jobID {
+			log.Printf("Worker [%s] aborting task [%d]", w.id, e.task.ID)
+			e.cancel()
+		}
+	}
+}
+
+func (w *Worker) track(task *storage.Task, cancel context.CancelFunc) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.running[task.ID] = &execution{task: task, cancel: cancel}
 }
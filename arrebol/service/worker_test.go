@@ -0,0 +1,26 @@
+package service
+
+import (
+	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/driver"
+	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"testing"
+)
+
+func TestWorkerSlots(t *testing.T) {
+	w := NewWorker(&driver.RawDriver{}, 2, Resources{}, nil)
+
+	if w.State() != Sleeping || !w.reserve(&storage.Task{}) {
+		t.Fatal("an idle worker should have a free slot")
+	}
+	if w.State() != Working || !w.reserve(&storage.Task{}) {
+		t.Fatal("a worker with a free slot left should take another task")
+	}
+	if w.State() != Busy || w.reserve(&storage.Task{}) || w.MatchAny(&storage.Task{}) {
+		t.Fatal("a worker with every slot taken should not take more tasks")
+	}
+
+	w.release(&storage.Task{})
+	if w.State() != Working {
+		t.Errorf("state = %d, want Working", w.State())
+	}
+}
@@ -1,15 +1,58 @@
 package worker
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	uuid "github.com/satori/go.uuid"
 	"time"
 )
 
+type State uint8
+
+const (
+	Alive State = iota
+	Lost
+)
+
+func (s State) String() string {
+	return [...]string{"Alive", "Lost"}[s]
+}
+
 type Worker struct {
 	Base
 	VCPU    float32 `json:"Vcpu"`
 	RAM     uint32  `json:"Ram"` //Megabytes
-	QueueID uint    `json:"QueueID, omitempty"`
+	QueueID uint    `json:"QueueID"`
+	Labels  Labels  `json:"Labels,omitempty"`
+	// liveness fields are kept out of the json since the worker signs its own representation
+	State    State     `json:"-"`
+	LastSeen time.Time `json:"-"`
+}
+
+// Labels describe a worker, so tasks may choose where they run
+type Labels map[string]string
+
+// Value stores the labels as a json object
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+func (l *Labels) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return errors.New("the labels of a worker must be a json object")
+	}
 }
 
 type Base struct {
@@ -0,0 +1,186 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File is a file to be written inside a container
+type File struct {
+	// Name is the slash-separated path of the file, relative to the directory it is written to
+	Name    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// WriteFile creates, or replaces, the file dest inside the container with the content.
+// The file is sent as a tar archive, so it is extracted at once.
+func WriteFile(ctx context.Context, cli *client.Client, id, dest string, content []byte, mode os.FileMode) error {
+	return WriteFiles(ctx, cli, id, path.Dir(dest), File{Name: path.Base(dest), Content: content, Mode: mode})
+}
+
+// WriteFiles writes the files under the directory dir of the container, which must exist.
+// The directories leading to the files are created, writable by any user.
+func WriteFiles(ctx context.Context, cli *client.Client, id, dir string, files ...File) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := make(map[string]bool)
+	now := time.Now()
+
+	for _, f := range files {
+		parents := strings.Split(path.Dir(path.Clean(f.Name)), "/")
+		for i := range parents {
+			name := strings.Join(parents[:i+1], "/")
+			if name == "." || dirs[name] {
+				continue
+			}
+			dirs[name] = true
+			header := &tar.Header{Name: name + "/", Typeflag: tar.TypeDir, Mode: 0777, ModTime: now}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+		}
+		header := &tar.Header{
+			Name:     path.Clean(f.Name),
+			Typeflag: tar.TypeReg,
+			Mode:     int64(f.Mode.Perm()),
+			Size:     int64(len(f.Content)),
+			ModTime:  now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return cli.CopyToContainer(ctx, id, dir, &buf, types.CopyToContainerOptions{})
+}
+
+// Upload copies the file or directory src of the host into the directory destDir of the container
+func Upload(ctx context.Context, cli *client.Client, id, src, destDir string) error {
+	log.Printf("Uploading [%s] to [%s] of Container [%s]", src, destDir, id)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive(src, writer))
+	}()
+	defer reader.Close()
+	return cli.CopyToContainer(ctx, id, destDir, reader, types.CopyToContainerOptions{})
+}
+
+// Download copies the file or directory src of the container into the directory destDir of the host
+func Download(ctx context.Context, cli *client.Client, id, src, destDir string) error {
+	log.Printf("Downloading [%s] of Container [%s] to [%s]", src, id, destDir)
+	reader, _, err := cli.CopyFromContainer(ctx, id, src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return extract(reader, destDir)
+}
+
+// archive writes src, along with its content when it is a directory, as a tar stream
+func archive(src string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(filepath.Clean(src))
+
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extract writes the entries of a tar stream under destDir, refusing the ones that would land outside of it
+func extract(r io.Reader, destDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		if target != filepath.Clean(destDir) && !strings.HasPrefix(target, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("the entry [%s] is outside of [%s]", header.Name, destDir)
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg:
+			err = writeEntry(target, tr, mode)
+		default:
+			// links and devices are not brought to the host
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeEntry(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
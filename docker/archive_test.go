@@ -0,0 +1,53 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveExtractRoundTrip(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	content := []byte{0, 1, 2, 'a', '\n', 255}
+	if err := os.MkdirAll(filepath.Join(src, "inputs", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "inputs", "nested", "data.bin"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := archive(filepath.Join(src, "inputs"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := extract(&buf, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dest, "inputs", "nested", "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %v, want %v", got, content)
+	}
+}
+
+func TestExtractRefusesEntriesOutsideDest(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: "../escaped", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	_, _ = tw.Write([]byte("x"))
+	_ = tw.Close()
+
+	dest := t.TempDir()
+	if err := extract(&buf, dest); err == nil {
+		t.Error("the entry outside of the destination should be refused")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dest), "escaped")); !os.IsNotExist(err) {
+		t.Error("the entry was written outside of the destination")
+	}
+}
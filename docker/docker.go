@@ -1,37 +1,63 @@
 package docker
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
 	"os"
 	"strings"
 	"time"
 )
 
+// The labels of the containers created by arrebol
+const (
+	ManagedLabel = "arrebol.managed"
+	JobLabel     = "arrebol.job"
+	TaskLabel    = "arrebol.task"
+)
+
 type ContainerConfig struct {
-	Name   string
-	Image  string
-	Mounts []mount.Mount
+	Name        string
+	Labels      map[string]string
+	Image       string
+	Mounts      []mount.Mount
+	Env         []string
+	WorkingDir  string
+	User        string
+	NetworkMode container.NetworkMode
+	Resources   container.Resources
 }
 
-func NewDockerClient(host string) *client.Client {
+// NewDockerClient creates a client of the docker daemon at the host, or at the default
+// one when the host is empty
+func NewDockerClient(host string) (*client.Client, error) {
 	if err := os.Setenv("DOCKER_HOST", host); err != nil {
-		log.Print(err)
+		return nil, err
+	}
+	return client.NewEnvClient()
+}
+
+// ValidHost tells if a client of the docker daemon may be created for the host
+func ValidHost(host string) error {
+	if host == "" {
+		return nil
 	}
-	cli, _ := client.NewEnvClient()
-	return cli
+	_, _, _, err := client.ParseHost(host)
+	return err
 }
 
-func ListContainer(cli *client.Client) {
-	ctns, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+func ListContainer(ctx context.Context, cli *client.Client) {
+	ctns, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -40,16 +66,21 @@ func ListContainer(cli *client.Client) {
 	}
 }
 
-func CreateContainer(cli *client.Client, config ContainerConfig) (string, error) {
+func CreateContainer(ctx context.Context, cli *client.Client, config ContainerConfig) (string, error) {
 	log.Printf("Creating Container [%s]", config.Name)
-	ctx := context.Background()
 	hostConfig := container.HostConfig{
-		Mounts: config.Mounts,
+		Mounts:      config.Mounts,
+		NetworkMode: config.NetworkMode,
+		Resources:   config.Resources,
 	}
 
 	dconfig := container.Config{
-		Image: config.Image,
-		Tty:   true,
+		Image:      config.Image,
+		Labels:     config.Labels,
+		Env:        config.Env,
+		WorkingDir: config.WorkingDir,
+		User:       config.User,
+		Tty:        true,
 	}
 
 	b, err := cli.ContainerCreate(ctx, &dconfig, &hostConfig, nil, config.Name)
@@ -57,91 +88,174 @@ func CreateContainer(cli *client.Client, config ContainerConfig) (string, error)
 	return b.ID, err
 }
 
-func StartContainer(cli *client.Client, id string) error {
+func StartContainer(ctx context.Context, cli *client.Client, id string) error {
 	log.Printf("Starting Container [%s]", id)
-	//ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	//defer cancel()
-	return cli.ContainerStart(context.Background(), id, types.ContainerStartOptions{})
+	return cli.ContainerStart(ctx, id, types.ContainerStartOptions{})
 }
 
-func StopContainer(cli *client.Client, id string) error {
+func StopContainer(ctx context.Context, cli *client.Client, id string) error {
 	log.Printf("Stopping Container [%s]", id)
 	var timeout = 5 * time.Second
-	return cli.ContainerStop(context.Background(), id, &timeout)
+	return cli.ContainerStop(ctx, id, &timeout)
 }
 
-func RemoveContainer(cli *client.Client, id string) error {
+func RemoveContainer(ctx context.Context, cli *client.Client, id string) error {
 	log.Printf("Removing Container [%s]", id)
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	// a running container is killed before being removed
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	return cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{})
+	return cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true})
 }
 
-func Write(cli *client.Client, id string, content []string, dest string) error {
-	for _, c := range content {
-		c = strings.ReplaceAll(c, "'", "'\"'\"'")
-		//c = AddSlashes(c)
-		cmd := fmt.Sprintf(`echo -E '%s' >> %s`, c, dest)
-		log.Printf("Writing [%s] on [%s] from Container [%s]", c, dest, id)
-		Exec(cli, id, cmd)
+// ListManagedContainers lists the IDs of the containers created by arrebol, running or not
+func ListManagedContainers(ctx context.Context, cli *client.Client) ([]string, error) {
+	args := filters.NewArgs()
+	args.Add("label", ManagedLabel+"=true")
+	ctns, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	ids := make([]string, 0, len(ctns))
+	for _, c := range ctns {
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
 }
 
-func Copy(cli *client.Client, id, src, dest string) error {
+// Write creates the file dest inside the container with one line for each of the content
+func Write(ctx context.Context, cli *client.Client, id string, content []string, dest string) error {
+	log.Printf("Writing [%d] lines on [%s] from Container [%s]", len(content), dest, id)
+	data := strings.Join(content, "\n")
+	if len(content) > 0 {
+		data += "\n"
+	}
+	return WriteFile(ctx, cli, id, dest, []byte(data), 0644)
+}
+
+// Copy copies the file src of the host to dest inside the container
+func Copy(ctx context.Context, cli *client.Client, id, src, dest string) error {
 	log.Printf("Copy [%s] to [%s] from Container [%s]", src, dest, id)
-	dat, _ := ioutil.ReadFile(src)
-	content := string(dat)
-	content = strings.ReplaceAll(content, "'", "'\"'\"'")
-	cmd := fmt.Sprintf("echo -E '%s' >| %s", content, dest)
-	return Exec(cli, id, cmd)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	dat, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return WriteFile(ctx, cli, id, dest, dat, info.Mode())
 }
 
-func Exec(cli *client.Client, id, cmd string) error {
+func Exec(ctx context.Context, cli *client.Client, id, cmd string) error {
 	log.Printf("Executing command [%s] on container [%s]", cmd, id)
 	config := types.ExecConfig{
 		Cmd: []string{"/bin/bash", "-c", cmd},
 	}
-	rid, _ := cli.ContainerExecCreate(context.Background(), id, config)
-	return cli.ContainerExecStart(context.Background(), rid.ID, types.ExecStartCheck{})
+	rid, err := cli.ContainerExecCreate(ctx, id, config)
+	if err != nil {
+		return err
+	}
+	return cli.ContainerExecStart(ctx, rid.ID, types.ExecStartCheck{})
 }
 
-func Cat(cli *client.Client, id, path string) ([]byte, error) {
-	log.Printf("Getting content of file [%s]", path)
+// AttachedExec is a command running inside a container whose output is being read
+type AttachedExec struct {
+	ID     string
+	Stdout io.Reader
+	conn   types.HijackedResponse
+	stdout *io.PipeReader
+}
+
+// ExecAttached runs the command inside the container, returning as soon as it starts.
+// Its stdout is read from the returned exec, while its stderr is copied to stderr.
+func ExecAttached(ctx context.Context, cli *client.Client, id string, cmd []string, stderr io.Writer) (*AttachedExec, error) {
+	log.Printf("Executing attached command %v on container [%s]", cmd, id)
 	config := types.ExecConfig{
-		Tty:          true,
-		AttachStderr: true,
 		AttachStdout: true,
-		Cmd:          []string{"/bin/bash", "-c", "cat " + path},
+		AttachStderr: true,
+		Cmd:          cmd,
 	}
-	rid, _ := cli.ContainerExecCreate(context.Background(), id, config)
-	hijack, _ := cli.ContainerExecAttach(context.Background(), rid.ID, types.ExecConfig{Tty: true})
-	output := read(hijack.Conn)
-	return output, nil
+	rid, err := cli.ContainerExecCreate(ctx, id, config)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := cli.ContainerExecAttach(ctx, rid.ID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		// without a tty both streams come multiplexed on the connection
+		_, err := stdcopy.StdCopy(writer, stderr, conn.Reader)
+		writer.CloseWithError(err)
+	}()
+	return &AttachedExec{ID: rid.ID, Stdout: reader, conn: conn, stdout: reader}, nil
 }
 
-func read(conn net.Conn) []byte {
-	result := make([]byte, 0)
-	b := make([]byte, 10)
+// ExitCode waits for the command to end, returning its exit code
+func (e *AttachedExec) ExitCode(ctx context.Context, cli *client.Client) (int, error) {
 	for {
-		n, _ := conn.Read(b)
-		result = append(result, b...)
-		if n < len(b) {
-			break
+		inspect, err := cli.ContainerExecInspect(ctx, e.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
 		}
-		b = make([]byte, 2*len(b))
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Close stops reading the output of the command, which keeps running
+func (e *AttachedExec) Close() {
+	_ = e.stdout.Close()
+	e.conn.Close()
+}
+
+// Cat gets the content of a file inside the container
+func Cat(ctx context.Context, cli *client.Client, id, path string) ([]byte, error) {
+	log.Printf("Getting content of file [%s]", path)
+	var buf bytes.Buffer
+	if err := ReadFile(ctx, cli, id, path, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadFile streams the content of a file inside the container to w
+func ReadFile(ctx context.Context, cli *client.Client, id, path string, w io.Writer) error {
+	log.Printf("Reading file [%s] from Container [%s]", path, id)
+	reader, _, err := cli.CopyFromContainer(ctx, id, path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	header, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return fmt.Errorf("[%s] is not a regular file", path)
 	}
-	return result
+	_, err = io.Copy(w, tr)
+	return err
 }
 
-func Pull(cli *client.Client, image string) (io.ReadCloser, error) {
-	reader, err := cli.ImagePull(context.Background(), image, types.ImagePullOptions{})
+func Pull(ctx context.Context, cli *client.Client, image string) (io.ReadCloser, error) {
+	reader, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
 	return reader, err
 }
 
-func CheckImage(cli *client.Client, image string) (exist bool, err error) {
+func CheckImage(ctx context.Context, cli *client.Client, image string) (exist bool, err error) {
 	exist = false
-	_, _, err = cli.ImageInspectWithRaw(context.Background(), image)
+	_, _, err = cli.ImageInspectWithRaw(ctx, image)
 	if err == nil {
 		exist = true
 	}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/ufcg-lsd/arrebol-pb/api"
 	"github.com/ufcg-lsd/arrebol-pb/api/worker"
 	"github.com/ufcg-lsd/arrebol-pb/arrebol/service"
 	"github.com/ufcg-lsd/arrebol-pb/storage"
+	"github.com/ufcg-lsd/arrebol-pb/storage/blob"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -34,13 +37,21 @@ func main() {
 
 	s := storage.New(os.Getenv("DATABASE_ADDRESS"), os.Getenv("DATABASE_PORT"), os.Getenv("DATABASE_USER"),
 		os.Getenv("DATABASE_NAME"), os.Getenv("DATABASE_PASSWORD"))
-	s.Setup()
 	defer s.Driver().Close()
 
+	if flag.Arg(0) == "migrate" {
+		migrate(s, flag.Args()[1:])
+		return
+	}
+	s.Setup()
+	blob.Setup()
+
 	var jobDispatcher = service.NewDispatcher(s)
 	go jobDispatcher.Start()
+	go service.NewReaper(jobDispatcher).Start()
 
 	a := api.New(s, jobDispatcher)
+	go service.NewTicker(jobDispatcher, a.SubmitScheduled).Start()
 
 	// Shutdown gracefully
 	go func() {
@@ -61,6 +72,53 @@ func main() {
 	}
 }
 
+// migrate handles the migrate subcommand, which manages the database schema
+func migrate(s *storage.Storage, args []string) {
+	const usage = "usage: migrate up | down [steps] | status"
+
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	switch args[0] {
+	case "up":
+		if err := s.Migrate(); err != nil {
+			log.Fatal(err.Error())
+		}
+		log.Printf("Schema migrated to version %d", storage.LatestVersion())
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatal(usage)
+			}
+		}
+		if err := s.Rollback(steps); err != nil {
+			log.Fatal(err.Error())
+		}
+	case "status":
+		version, err := s.SchemaVersion()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		status, err := s.MigrationsStatus()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Printf("Schema version %d, latest known version %d\n", version, storage.LatestVersion())
+		for _, m := range status {
+			state := "pending"
+			if m.Applied {
+				state = "applied at " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+		}
+	default:
+		log.Fatal(usage)
+	}
+}
+
 func startWorkerApi(storage *storage.Storage) {
 	const WorkerApiPort = "8000"
 
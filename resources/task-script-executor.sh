@@ -1,9 +1,13 @@
 #!/bin/bash
 
-# Read the task script file and execute one command at a time, saving your exitcodes in the .ts.ec file.
-# Each command executed is written to the .cmds file.
+# Read the task script file, holding each command encoded in base64 on a line of its own, and
+# execute one command at a time, reporting the progress on stdout:
+#   ARREBOL START <n>         the n-th command, counting from 0, started
+#   ARREBOL END <n> <code>    the n-th command ended with the exit code
+#   ARREBOL DONE              every command was executed
+# The stdout and stderr of the n-th command are written to the n.out and n.err files,
+# next to the task script file.
 # Use -tsf= or --task_filepath= to input the task file path (Required).
-# Use the flag -d or --debug to store .out and .err from execution (Optional).
 
 # This flag does the execution not stop on non-zero exit code commands
 set +e
@@ -15,10 +19,6 @@ do
 	    __TASK_SCRIPT_FILEPATH="${i#*=}"
 	    shift
 	    ;;
-	    -d|--debug)
-	    DEBUG=YES
-	    shift
-	    ;;
 	    *)
 	        # unknown option
 	    ;;
@@ -27,31 +27,22 @@ done
 
 if [ ! -f "$__TASK_SCRIPT_FILEPATH" ];
 then
-	echo "$__TASK_SCRIPT_FILEPATH is not a file"
+	echo "$__TASK_SCRIPT_FILEPATH is not a file" >&2
 	exit 17
 fi
 
-TS_FILENAME=$(basename $__TASK_SCRIPT_FILEPATH)
-
-__EXIT_CODES=/tmp/$TS_FILENAME.ec
-rm $__EXIT_CODES
-touch $__EXIT_CODES
+__TASK_DIR=$(dirname "$__TASK_SCRIPT_FILEPATH")
 
-__COMMANDS=/tmp/$TS_FILENAME.cmds
-rm $__COMMANDS
-touch $__COMMANDS
-
-if [ -n "$DEBUG" ];
-then
-	rm /tmp/$TS_FILENAME.out
-	exec 1> /tmp/$TS_FILENAME.out
-	rm /tmp/$TS_FILENAME.err
-	exec 2> /tmp/$TS_FILENAME.err
-fi
+# the progress is reported on a descriptor of its own, which the commands do not see
+exec 3>&1
 
+__INDEX=0
 while IFS= read -r __line || [ -n "$__line" ]; do
-	echo $__line >> $__COMMANDS
-	set +e
-    eval $__line
-    echo "$?" >> $__EXIT_CODES
-done < $__TASK_SCRIPT_FILEPATH
\ No newline at end of file
+	__command=$(printf '%s' "$__line" | base64 -d)
+	echo "ARREBOL START $__INDEX" >&3
+	eval "$__command" < /dev/null > "$__TASK_DIR/$__INDEX.out" 2> "$__TASK_DIR/$__INDEX.err" 3>&-
+	echo "ARREBOL END $__INDEX $?" >&3
+	__INDEX=$((__INDEX + 1))
+done < "$__TASK_SCRIPT_FILEPATH"
+
+echo "ARREBOL DONE" >&3
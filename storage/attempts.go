@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"time"
+)
+
+// StartAttempt records that the worker has just started a new attempt of the task
+func (s *Storage) StartAttempt(taskID uint, workerID string) (*TaskAttempt, error) {
+	var count int
+	if err := s.driver.Model(&TaskAttempt{}).Where("task_id = ?", taskID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	attempt := &TaskAttempt{
+		TaskID:    taskID,
+		Number:    count + 1,
+		WorkerID:  workerID,
+		StartedAt: time.Now(),
+		State:     TaskRunning,
+	}
+	return attempt, s.driver.Create(attempt).Error
+}
+
+// EndAttempt records the end of the attempt, along with the exit codes of the commands of the task
+func (s *Storage) EndAttempt(attempt *TaskAttempt, task *Task) error {
+	attempt.end(task)
+	return s.driver.Save(attempt).Error
+}
+
+// end records on the attempt the state the task ended in and the exit codes of its commands
+func (a *TaskAttempt) end(task *Task) {
+	now := time.Now()
+	a.EndedAt = &now
+	a.State = task.State
+	a.ExitCodes = make(ExitCodes, len(task.Commands))
+	for i, cmd := range task.Commands {
+		a.ExitCodes[i] = cmd.ExitCode
+	}
+}
+
+// RetrieveOpenAttempt returns the attempt of the task the worker has not ended yet
+func (s *Storage) RetrieveOpenAttempt(taskID uint, workerID string) (*TaskAttempt, error) {
+	var attempt TaskAttempt
+	err := s.driver.Where("task_id = ? AND worker_id = ? AND ended_at IS NULL", taskID, workerID).
+		Order("number DESC").First(&attempt).Error
+	return &attempt, err
+}
+
+func (s *Storage) RetrieveAttempts(taskID uint) ([]*TaskAttempt, error) {
+	var attempts []*TaskAttempt
+	err := s.driver.Where("task_id = ?", taskID).Order("number").Find(&attempts).Error
+	return attempts, err
+}
+
+// RetryTask gives back to the queue the task the worker has just failed, resetting its
+// commands, so that it runs again once retryAt has passed
+func (s *Storage) RetryTask(taskID uint, workerID string, retryAt time.Time) error {
+	tx := s.driver.Begin()
+	db := tx.Model(&Task{}).Where("id = ? AND worker_id = ? AND state = ?", taskID, workerID, TaskRunning).
+		Updates(map[string]interface{}{
+			"state":            TaskPending,
+			"worker_id":        "",
+			"lease_expires_at": nil,
+			"retry_at":         retryAt,
+		})
+	err := db.Error
+	if err == nil && db.RowsAffected == 0 {
+		err = LeaseNotHeldErr
+	}
+	if err == nil {
+		err = tx.Model(&Command{}).Where("task_id = ?", taskID).
+			Updates(map[string]interface{}{"state": CmdNotStarted, "exit_code": -1}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
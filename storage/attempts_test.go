@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEndAttemptOfTimedOutTask(t *testing.T) {
+	task := &Task{State: TaskFailed, Commands: []*Command{
+		{State: CmdFinished, ExitCode: 0},
+		{State: CmdTimedOut, ExitCode: 124},
+		{State: CmdCancelled, ExitCode: -1},
+	}}
+	attempt := &TaskAttempt{State: TaskRunning}
+	attempt.end(task)
+
+	if attempt.State != TaskFailed || attempt.EndedAt == nil {
+		t.Errorf("attempt ended %s at %v, want failed with an end", attempt.State, attempt.EndedAt)
+	}
+	if want := (ExitCodes{0, 124, -1}); !reflect.DeepEqual(attempt.ExitCodes, want) {
+		t.Errorf("exit codes = %v, want %v", attempt.ExitCodes, want)
+	}
+}
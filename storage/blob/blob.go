@@ -0,0 +1,112 @@
+// Package blob keeps the contents that are too large to live in the database,
+// such as the output of the commands. Contents are addressed by slash-separated keys.
+package blob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	StorePathKey     = "BLOB_STORE_PATH"
+	DefaultStorePath = "/var/lib/arrebol/blobs"
+)
+
+var (
+	NotFoundErr   = errors.New("blob not found")
+	InvalidKeyErr = errors.New("invalid blob key")
+)
+
+// DefaultStore is the store used by the drivers and the api, set up on startup
+var DefaultStore Store
+
+type Store interface {
+	// Create opens the blob for writing, replacing its previous content
+	Create(key string) (io.WriteCloser, error)
+	// Open opens the blob for reading
+	Open(key string) (Blob, error)
+}
+
+type Blob interface {
+	io.ReadSeeker
+	io.Closer
+	Size() int64
+	ModTime() time.Time
+}
+
+// Setup sets the default store up on the local filesystem
+func Setup() {
+	root := os.Getenv(StorePathKey)
+	if root == "" {
+		root = DefaultStorePath
+	}
+	DefaultStore = NewFileStore(root)
+}
+
+// FileStore keeps each blob as a file under its root directory
+type FileStore struct {
+	root string
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: root}
+}
+
+func (fs *FileStore) Create(key string) (io.WriteCloser, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+func (fs *FileStore) Open(key string) (Blob, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, NotFoundErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &file{File: f, info: info}, nil
+}
+
+// path maps the key to its file, refusing the keys that are absolute or not clean, so
+// that none of them reaches out of the root
+func (fs *FileStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || strings.HasPrefix(key, "/") || clean != "/"+key {
+		return "", InvalidKeyErr
+	}
+	return filepath.Join(fs.root, filepath.FromSlash(clean)), nil
+}
+
+type file struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (f *file) Size() int64 {
+	return f.info.Size()
+}
+
+func (f *file) ModTime() time.Time {
+	return f.info.ModTime()
+}
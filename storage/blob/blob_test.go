@@ -0,0 +1,63 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorePath(t *testing.T) {
+	fs := NewFileStore("/var/lib/arrebol/blobs")
+	valid := map[string]string{
+		"tasks/1/commands/2/stdout": "/var/lib/arrebol/blobs/tasks/1/commands/2/stdout",
+		"stdout":                    "/var/lib/arrebol/blobs/stdout",
+	}
+	for key, want := range valid {
+		if p, err := fs.path(key); err != nil || p != want {
+			t.Errorf("path(%q) = %q, %v, want %q", key, p, err, want)
+		}
+	}
+
+	invalid := []string{"", "/", "/etc/passwd", "..", "../etc/passwd", "tasks/../../etc/passwd", "tasks/..", "tasks//stdout", "tasks/./stdout", "tasks/"}
+	for _, key := range invalid {
+		if p, err := fs.path(key); err != InvalidKeyErr {
+			t.Errorf("path(%q) = %q, %v, want InvalidKeyErr", key, p, err)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	fs := NewFileStore(filepath.Join(root, "store"))
+
+	if _, err := fs.Open("tasks/1/stdout"); err != NotFoundErr {
+		t.Errorf("Open of a missing blob = %v, want NotFoundErr", err)
+	}
+	if _, err := fs.Create("../outside"); err != InvalidKeyErr {
+		t.Errorf("Create out of the root = %v, want InvalidKeyErr", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside")); !os.IsNotExist(err) {
+		t.Errorf("a blob was created out of the root: %v", err)
+	}
+
+	w, err := fs.Create("tasks/1/stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("hello\n"))
+	w.Close()
+	b, err := fs.Open("tasks/1/stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	content, _ := ioutil.ReadAll(b)
+	if string(content) != "hello\n" || b.Size() != 6 {
+		t.Errorf("blob = %q of size %d, want %q", content, b.Size(), "hello\n")
+	}
+}
@@ -27,3 +27,8 @@ func (q Queue) QueueHasJob(jobId uint) bool {
 	}
 	return false
 }
+
+// IsCompleted tells if the job has reached a final state
+func (js JobState) IsCompleted() bool {
+	return js == JobFinished || js == JobFailed || js == JobCancelled
+}
@@ -25,10 +25,13 @@ func (s *Storage) SaveJob(job *Job) error {
 	return s.driver.Save(&job).Error
 }
 
+// SetJobState updates the state of a job, unless it was cancelled
 func (s *Storage) SetJobState(jobID uint, state JobState) {
-	var job Job
-	s.driver.First(&job, jobID)
-	s.driver.Model(&job).Update("State", state)
+	s.driver.Model(&Job{}).Where("id = ? AND state <> ?", jobID, JobCancelled).Update("state", state)
+}
+
+func (s *Storage) SetJobPriority(jobID uint, priority int) error {
+	return s.driver.Model(&Job{}).Where("id = ?", jobID).Update("priority", priority).Error
 }
 
 func (s *Storage) SaveTask(task *Task) error {
@@ -80,4 +83,36 @@ func (s *Storage) fillTask(task *Task) {
 	db.Related(&task.Commands)
 	db.Related(&task.Metadata)
 	db.Related(&task.Config)
+	s.driver.Where("task_id = ?", task.ID).Order("number").Find(&task.Attempts)
+}
+
+func (s *Storage) RetrieveJob(jobID uint) (*Job, error) {
+	var job Job
+	err := s.driver.First(&job, jobID).Error
+	return &job, err
+}
+
+// CancelJob marks the job as cancelled, along with every task that has not completed yet
+// and every command of them that has not started. Running commands are left to the workers,
+// which record how they ended once aborted.
+func (s *Storage) CancelJob(jobID uint) error {
+	active := []TaskState{TaskPending, TaskRunning, TaskWaiting}
+
+	tx := s.driver.Begin()
+	err := tx.Model(&Job{}).Where("id = ?", jobID).Update("state", JobCancelled).Error
+	if err == nil {
+		err = tx.Model(&Command{}).
+			Where("state = ? AND task_id IN (SELECT id FROM tasks WHERE job_id = ? AND state IN (?))",
+				CmdNotStarted, jobID, active).
+			Update("state", CmdCancelled).Error
+	}
+	if err == nil {
+		err = tx.Model(&Task{}).Where("job_id = ? AND state IN (?)", jobID, active).
+			Updates(map[string]interface{}{"state": TaskCancelled, "lease_expires_at": nil}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
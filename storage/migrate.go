@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	NewerSchemaErr = errors.New("the database schema is newer than the one supported by this version of arrebol")
+)
+
+// Migration is a versioned change on the database schema. Migrations are applied in
+// ascending order of version and each applied migration is recorded in the schema_migrations table.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration is the record of an applied migration
+type SchemaMigration struct {
+	Version   uint      `gorm:"primary_key;auto_increment:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+type MigrationStatus struct {
+	Version   uint
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// sqlMigration builds a migration from plain SQL scripts
+func sqlMigration(version uint, name, up, down string) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec(up).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec(down).Error
+		},
+	}
+}
+
+// LatestVersion is the schema version supported by this version of arrebol
+func LatestVersion() uint {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion is the version of the last migration applied on the database
+func (s *Storage) SchemaVersion() (uint, error) {
+	if err := s.createMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var versions []uint
+	err := s.driver.Model(&SchemaMigration{}).Order("version desc").Limit(1).Pluck("version", &versions).Error
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[0], nil
+}
+
+// CheckSchema fails when the database was migrated by a newer version of arrebol
+func (s *Storage) CheckSchema() error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > LatestVersion() {
+		return fmt.Errorf("%w: found version %d, latest known is %d", NewerSchemaErr, version, LatestVersion())
+	}
+	return nil
+}
+
+// Migrate applies every pending migration, each one in its own transaction.
+func (s *Storage) Migrate() error {
+	if err := s.CheckSchema(); err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Printf("Applying migration %s", m)
+		tx := s.driver.Begin()
+		if err = m.Up(tx); err == nil {
+			err = tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %w", m, err)
+		}
+		if err = tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the last steps applied migrations, newest first.
+func (s *Storage) Rollback(steps int) error {
+	if err := s.CheckSchema(); err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		log.Printf("Reverting migration %s", m)
+		tx := s.driver.Begin()
+		if err = m.Down(tx); err == nil {
+			err = tx.Delete(&SchemaMigration{Version: m.Version}).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("revert of migration %s failed: %w", m, err)
+		}
+		if err = tx.Commit().Error; err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+// MigrationsStatus lists the known migrations telling which ones were applied
+func (s *Storage) MigrationsStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, m := range migrations {
+		record, ok := applied[m.Version]
+		status = append(status, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return status, nil
+}
+
+func (s *Storage) appliedMigrations() (map[uint]SchemaMigration, error) {
+	if err := s.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+	var records []SchemaMigration
+	if err := s.driver.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]SchemaMigration)
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+func (s *Storage) createMigrationsTable() error {
+	if s.driver.HasTable(&SchemaMigration{}) {
+		return nil
+	}
+	return s.driver.CreateTable(&SchemaMigration{}).Error
+}
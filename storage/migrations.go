@@ -0,0 +1,227 @@
+package storage
+
+// migrations holds every change on the schema, in ascending order of version.
+// Applied migrations must never be edited; change the schema by appending a new one.
+var migrations = []Migration{
+	sqlMigration(1, "initial_schema", `
+		CREATE TABLE IF NOT EXISTS queues (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			name text
+		);
+		CREATE INDEX IF NOT EXISTS idx_queues_deleted_at ON queues (deleted_at);
+
+		CREATE TABLE IF NOT EXISTS resource_nodes (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			queue_id integer,
+			state integer,
+			address text
+		);
+		CREATE INDEX IF NOT EXISTS idx_resource_nodes_deleted_at ON resource_nodes (deleted_at);
+
+		CREATE TABLE IF NOT EXISTS jobs (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			queue_id integer,
+			label text,
+			state integer
+		);
+		CREATE INDEX IF NOT EXISTS idx_jobs_deleted_at ON jobs (deleted_at);
+
+		CREATE TABLE IF NOT EXISTS tasks (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			job_id integer,
+			state integer,
+			worker_id text,
+			lease_expires_at timestamp with time zone
+		);
+		CREATE INDEX IF NOT EXISTS idx_tasks_deleted_at ON tasks (deleted_at);
+
+		CREATE TABLE IF NOT EXISTS task_configs (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			task_id integer,
+			key text,
+			value text
+		);
+		CREATE INDEX IF NOT EXISTS idx_task_configs_deleted_at ON task_configs (deleted_at);
+
+		CREATE TABLE IF NOT EXISTS task_metadata (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			task_id integer,
+			key text,
+			value text
+		);
+		CREATE INDEX IF NOT EXISTS idx_task_metadata_deleted_at ON task_metadata (deleted_at);
+
+		CREATE TABLE IF NOT EXISTS commands (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			task_id integer,
+			exit_code integer,
+			raw_command text,
+			state integer
+		);
+		CREATE INDEX IF NOT EXISTS idx_commands_deleted_at ON commands (deleted_at);
+
+		CREATE TABLE IF NOT EXISTS workers (
+			id uuid PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			v_cpu numeric,
+			ram bigint,
+			queue_id integer,
+			state integer,
+			last_seen timestamp with time zone
+		);
+		CREATE INDEX IF NOT EXISTS idx_workers_deleted_at ON workers (deleted_at);
+
+		-- databases created before the migrations keep their tables, which lack the lease and
+		-- heartbeat columns; their workers are taken as alive and just seen
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS worker_id text;
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS lease_expires_at timestamp with time zone;
+		ALTER TABLE workers ADD COLUMN IF NOT EXISTS state integer DEFAULT 0;
+		ALTER TABLE workers ADD COLUMN IF NOT EXISTS last_seen timestamp with time zone DEFAULT now();
+
+		-- databases created before the migrations already have the foreign keys
+		DO $$
+		DECLARE
+			fk record;
+		BEGIN
+			FOR fk IN SELECT * FROM (VALUES
+				('commands', 'task_id', 'tasks'),
+				('task_metadata', 'task_id', 'tasks'),
+				('task_configs', 'task_id', 'tasks'),
+				('tasks', 'job_id', 'jobs'),
+				('resource_nodes', 'queue_id', 'queues'),
+				('jobs', 'queue_id', 'queues'),
+				('workers', 'queue_id', 'queues')
+			) AS v (tbl, col, ref)
+			LOOP
+				IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = fk.tbl::regclass AND contype = 'f') THEN
+					EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I FOREIGN KEY (%I) REFERENCES %I(id) ON DELETE CASCADE ON UPDATE CASCADE',
+						fk.tbl, fk.tbl || '_' || fk.col || '_' || fk.ref || '_id__foreign', fk.col, fk.ref);
+				END IF;
+			END LOOP;
+		END
+		$$;`, `
+		DROP TABLE IF EXISTS commands, task_configs, task_metadata, tasks, jobs, resource_nodes, workers, queues;`),
+	sqlMigration(2, "queue_policy", `
+		ALTER TABLE queues ADD COLUMN IF NOT EXISTS policy text NOT NULL DEFAULT 'fifo';`, `
+		ALTER TABLE queues DROP COLUMN IF EXISTS policy;`),
+	sqlMigration(3, "job_priority", `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS priority integer NOT NULL DEFAULT 0;
+		ALTER TABLE queues ALTER COLUMN policy SET DEFAULT 'priority';`, `
+		ALTER TABLE queues ALTER COLUMN policy SET DEFAULT 'fifo';
+		ALTER TABLE jobs DROP COLUMN IF EXISTS priority;`),
+	sqlMigration(4, "worker_labels", `
+		ALTER TABLE workers ADD COLUMN IF NOT EXISTS labels jsonb;
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS reason text NOT NULL DEFAULT '';`, `
+		ALTER TABLE tasks DROP COLUMN IF EXISTS reason;
+		ALTER TABLE workers DROP COLUMN IF EXISTS labels;`),
+	sqlMigration(5, "task_attempts", `
+		CREATE TABLE IF NOT EXISTS task_attempts (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			task_id integer REFERENCES tasks(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			number integer,
+			worker_id text,
+			started_at timestamp with time zone,
+			ended_at timestamp with time zone,
+			state integer,
+			exit_codes jsonb,
+			infrastructure boolean NOT NULL DEFAULT false,
+			error text
+		);
+		CREATE INDEX IF NOT EXISTS idx_task_attempts_deleted_at ON task_attempts (deleted_at);
+		CREATE INDEX IF NOT EXISTS idx_task_attempts_task_id ON task_attempts (task_id);
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS retry_at timestamp with time zone;`, `
+		ALTER TABLE tasks DROP COLUMN IF EXISTS retry_at;
+		DROP TABLE IF EXISTS task_attempts;`),
+	sqlMigration(6, "task_dependencies", `
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS spec_id text NOT NULL DEFAULT '';
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS depends_on jsonb;
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS on_upstream_failure text NOT NULL DEFAULT 'skip';`, `
+		ALTER TABLE jobs DROP COLUMN IF EXISTS on_upstream_failure;
+		ALTER TABLE tasks DROP COLUMN IF EXISTS depends_on;
+		ALTER TABLE tasks DROP COLUMN IF EXISTS spec_id;`),
+	sqlMigration(7, "task_array_index", `
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS array_index integer;`, `
+		ALTER TABLE tasks DROP COLUMN IF EXISTS array_index;`),
+	sqlMigration(8, "schedules", `
+		CREATE TABLE IF NOT EXISTS schedules (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			queue_id integer REFERENCES queues(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			name text,
+			cron text,
+			timezone text,
+			overlap text NOT NULL DEFAULT 'skip',
+			paused boolean NOT NULL DEFAULT false,
+			spec text,
+			next_run_at timestamp with time zone
+		);
+		CREATE INDEX IF NOT EXISTS idx_schedules_deleted_at ON schedules (deleted_at);
+		CREATE INDEX IF NOT EXISTS idx_schedules_next_run_at ON schedules (next_run_at);
+		CREATE TABLE IF NOT EXISTS schedule_runs (
+			id serial PRIMARY KEY,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			schedule_id integer REFERENCES schedules(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			fired_at timestamp with time zone,
+			outcome integer,
+			job_id integer REFERENCES jobs(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			reason text
+		);
+		CREATE INDEX IF NOT EXISTS idx_schedule_runs_deleted_at ON schedule_runs (deleted_at);
+		CREATE INDEX IF NOT EXISTS idx_schedule_runs_schedule_id ON schedule_runs (schedule_id);`, `
+		DROP TABLE IF EXISTS schedule_runs;
+		DROP TABLE IF EXISTS schedules;`),
+	sqlMigration(9, "node_capacity", `
+		ALTER TABLE resource_nodes ADD COLUMN IF NOT EXISTS driver text NOT NULL DEFAULT 'raw';
+		ALTER TABLE resource_nodes ADD COLUMN IF NOT EXISTS workers integer NOT NULL DEFAULT 1;
+		ALTER TABLE resource_nodes ADD COLUMN IF NOT EXISTS slots integer NOT NULL DEFAULT 1;
+		ALTER TABLE resource_nodes ADD COLUMN IF NOT EXISTS cpu double precision NOT NULL DEFAULT 0;
+		ALTER TABLE resource_nodes ADD COLUMN IF NOT EXISTS memory bigint NOT NULL DEFAULT 0;
+		ALTER TABLE resource_nodes ADD COLUMN IF NOT EXISTS disk bigint NOT NULL DEFAULT 0;
+		ALTER TABLE resource_nodes ADD COLUMN IF NOT EXISTS labels jsonb;`, `
+		ALTER TABLE resource_nodes DROP COLUMN IF EXISTS labels;
+		ALTER TABLE resource_nodes DROP COLUMN IF EXISTS disk;
+		ALTER TABLE resource_nodes DROP COLUMN IF EXISTS memory;
+		ALTER TABLE resource_nodes DROP COLUMN IF EXISTS cpu;
+		ALTER TABLE resource_nodes DROP COLUMN IF EXISTS slots;
+		ALTER TABLE resource_nodes DROP COLUMN IF EXISTS workers;
+		ALTER TABLE resource_nodes DROP COLUMN IF EXISTS driver;`),
+	sqlMigration(10, "queue_state", `
+		ALTER TABLE queues ADD COLUMN IF NOT EXISTS state integer NOT NULL DEFAULT 0;`, `
+		ALTER TABLE queues DROP COLUMN IF EXISTS state;`),
+	sqlMigration(11, "queue_name_unique", `
+		UPDATE queues SET name = name || '-' || id
+			WHERE deleted_at IS NULL AND name <> '' AND id NOT IN (
+				SELECT MIN(id) FROM queues WHERE deleted_at IS NULL GROUP BY name);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_queues_name ON queues (name) WHERE deleted_at IS NULL AND name <> '';`, `
+		DROP INDEX IF EXISTS idx_queues_name;`),
+}
@@ -0,0 +1,21 @@
+package storage
+
+import "testing"
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	var last uint
+
+	for _, m := range migrations {
+		if m.Version != last+1 {
+			t.Errorf("expected migration version %d but got %d", last+1, m.Version)
+		}
+		if m.Name == "" || m.Up == nil || m.Down == nil {
+			t.Errorf("migration %s must have a name, an up and a down", m)
+		}
+		last = m.Version
+	}
+
+	if LatestVersion() != last {
+		t.Errorf("expected latest version %d but got %d", last, LatestVersion())
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+func (s *Storage) SaveNode(node *ResourceNode) error {
+	return s.driver.Save(node).Error
+}
+
+func (s *Storage) RetrieveNode(nodeID, queueID uint) (*ResourceNode, error) {
+	var node ResourceNode
+	err := s.driver.Where("queue_id = ?", queueID).First(&node, nodeID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// QueueHadNode tells if a node was ever registered to the queue, counting the ones drained
+// and removed since
+func (s *Storage) QueueHadNode(queueID uint) (bool, error) {
+	var count int
+	err := s.driver.Unscoped().Model(&ResourceNode{}).Where("queue_id = ?", queueID).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *Storage) RetrieveNodesByQueueID(queueID uint) ([]*ResourceNode, error) {
+	var nodes []*ResourceNode
+	err := s.driver.Where("queue_id = ?", queueID).Order("id").Find(&nodes).Error
+	return nodes, err
+}
+
+// RetrieveNodes returns the nodes of every queue
+func (s *Storage) RetrieveNodes() ([]*ResourceNode, error) {
+	var nodes []*ResourceNode
+	err := s.driver.Order("id").Find(&nodes).Error
+	return nodes, err
+}
+
+// SetNodeState updates the state of a node, unless it is draining
+func (s *Storage) SetNodeState(nodeID uint, state ResourceState) error {
+	return s.driver.Model(&ResourceNode{}).Where("id = ? AND state <> ?", nodeID, Draining).Update("state", state).Error
+}
+
+// DrainNode marks the node as draining. It returns false when it already was.
+func (s *Storage) DrainNode(nodeID uint) (bool, error) {
+	db := s.driver.Model(&ResourceNode{}).Where("id = ? AND state <> ?", nodeID, Draining).Update("state", Draining)
+	return db.RowsAffected == 1, db.Error
+}
+
+func (s *Storage) DeleteNode(nodeID uint) error {
+	return s.driver.Delete(&ResourceNode{}, "id = ?", nodeID).Error
+}
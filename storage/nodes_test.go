@@ -0,0 +1,28 @@
+package storage
+
+import "testing"
+
+func TestQueueHadNode(t *testing.T) {
+	s := OpenDriver()
+	s.Setup()
+	defer CloseDriver(s, t)
+	queue := &Queue{}
+	if err := s.CreateQueue(queue); err != nil {
+		t.Fatal(err)
+	}
+
+	if had, err := s.QueueHadNode(queue.ID); err != nil || had {
+		t.Fatalf("a new queue had a node: %v, %v", had, err)
+	}
+	node := &ResourceNode{QueueID: queue.ID, Workers: 1, Slots: 1}
+	if err := s.SaveNode(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteNode(node.ID); err != nil {
+		t.Fatal(err)
+	}
+	// a drained node still counts, so that the queue is not seeded again
+	if had, err := s.QueueHadNode(queue.ID); err != nil || !had {
+		t.Errorf("the removed node was not counted: %v, %v", had, err)
+	}
+}
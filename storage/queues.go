@@ -1,5 +1,68 @@
 package storage
 
+import (
+	"errors"
+	"github.com/lib/pq"
+	"strconv"
+)
+
+var QueueNameTakenErr = errors.New("the queue name is taken by another queue")
+
+// DefaultQueueID is the ID of the queue created along with the database
+const DefaultQueueID = 1
+
+// uniqueViolation is the code of the postgres error for a duplicated unique key
+const uniqueViolation = "23505"
+
+// CreateQueue inserts a new queue, whose ID is assigned by the database. It fails when the
+// name, if the queue has one, is taken.
+func (s *Storage) CreateQueue(q *Queue) error {
+	if err := s.checkQueueName(0, q.Name); err != nil {
+		return err
+	}
+	q.ID = 0
+	return queueNameErr(s.driver.Create(q).Error)
+}
+
+// checkQueueName tells if the name is free to be given to the queue
+func (s *Storage) checkQueueName(queueID uint, name string) error {
+	if name == "" {
+		return nil
+	}
+	var count int
+	err := s.driver.Model(&Queue{}).Where("name = ? AND id <> ?", name, queueID).Count(&count).Error
+	if err == nil && count > 0 {
+		err = QueueNameTakenErr
+	}
+	return err
+}
+
+// queueNameErr tells the name was taken when the database refuses it, which happens when
+// another queue takes it between the check and the write
+func queueNameErr(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		return QueueNameTakenErr
+	}
+	return err
+}
+
+// ResolveQueueID reads a reference to a queue, either its ID or its name. Names made only of
+// digits are read as IDs.
+func (s *Storage) ResolveQueueID(ref string) (uint, error) {
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		return uint(id), nil
+	}
+	return s.RetrieveQueueIDByName(ref)
+}
+
+func (s *Storage) RetrieveQueueIDByName(name string) (uint, error) {
+	var queue Queue
+	if err := s.driver.Where("name = ?", name).First(&queue).Error; err != nil {
+		return 0, err
+	}
+	return queue.ID, nil
+}
+
 func (s *Storage) SaveQueue(q *Queue) error {
 	return s.driver.Save(&q).Error
 }
@@ -9,10 +72,55 @@ func (s *Storage) RetrieveQueue(queueID uint) (*Queue, error) {
 	err := s.driver.First(&queue, queueID).Error
 	queue.Jobs, _ = s.RetrieveJobsByQueueID(queueID)
 	queue.Workers, _ = s.RetrieveWorkersByQueueID(queueID)
-	// TODO Retrieve resource nodes of queue
+	queue.Nodes, _ = s.RetrieveNodesByQueueID(queueID)
 	return &queue, err
 }
 
+// QueueUpdate holds the fields of a queue to be changed, the nil ones being kept
+type QueueUpdate struct {
+	Name   *string
+	Policy *string
+	State  *QueueState
+}
+
+// UpdateQueue changes the fields of the queue at once. It fails when the name is taken by another queue.
+func (s *Storage) UpdateQueue(queueID uint, update QueueUpdate) error {
+	fields := map[string]interface{}{}
+	if update.Name != nil {
+		if err := s.checkQueueName(queueID, *update.Name); err != nil {
+			return err
+		}
+		fields["name"] = *update.Name
+	}
+	if update.Policy != nil {
+		fields["policy"] = *update.Policy
+	}
+	if update.State != nil {
+		fields["state"] = *update.State
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return queueNameErr(s.driver.Model(&Queue{}).Where("id = ?", queueID).Updates(fields).Error)
+}
+
+// DeleteQueue deletes the queue along with its schedules and nodes. Its jobs are kept.
+func (s *Storage) DeleteQueue(queueID uint) error {
+	tx := s.driver.Begin()
+	err := tx.Delete(&Schedule{}, "queue_id = ?", queueID).Error
+	if err == nil {
+		err = tx.Delete(&ResourceNode{}, "queue_id = ?", queueID).Error
+	}
+	if err == nil {
+		err = tx.Delete(&Queue{}, "id = ?", queueID).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
 func (s *Storage) RetrieveQueues() ([]*Queue, error) {
 	var queues []*Queue
 
@@ -23,8 +131,7 @@ func (s *Storage) RetrieveQueues() ([]*Queue, error) {
 
 func (s *Storage) GetDefaultQueue() (*Queue, error) {
 	var queue Queue
-	const QIDDefault = 1
-	if err := s.driver.Where("id = ?", QIDDefault).First(&queue).Error; err == nil {
+	if err := s.driver.Where("id = ?", DefaultQueueID).First(&queue).Error; err == nil {
 		s.driver.First(&queue, 1)
 	} else {
 		return nil, err
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"time"
+)
+
+func (s *Storage) SaveSchedule(schedule *Schedule) error {
+	return s.driver.Save(schedule).Error
+}
+
+// RetrieveSchedule returns the schedule of the queue along with its runs, the latest first
+func (s *Storage) RetrieveSchedule(scheduleID, queueID uint) (*Schedule, error) {
+	var schedule Schedule
+	err := s.driver.Where("queue_id = ?", queueID).First(&schedule, scheduleID).Error
+	if err != nil {
+		return nil, err
+	}
+	err = s.driver.Where("schedule_id = ?", schedule.ID).Order("fired_at DESC, id DESC").Find(&schedule.Runs).Error
+	return &schedule, err
+}
+
+func (s *Storage) RetrieveSchedulesByQueueID(queueID uint) ([]*Schedule, error) {
+	var schedules []*Schedule
+	err := s.driver.Where("queue_id = ?", queueID).Order("id").Find(&schedules).Error
+	return schedules, err
+}
+
+// RetrieveDueSchedules returns the schedules not paused that are due to fire at the time
+func (s *Storage) RetrieveDueSchedules(now time.Time) ([]*Schedule, error) {
+	var schedules []*Schedule
+	err := s.driver.Where("NOT paused AND next_run_at <= ?", now).Order("next_run_at").Find(&schedules).Error
+	return schedules, err
+}
+
+// AdvanceSchedule moves a schedule due to fire at the time to its next firing. It returns
+// false when the schedule is no longer due at that time, e.g. because it was paused or it
+// was fired in the meantime.
+func (s *Storage) AdvanceSchedule(scheduleID uint, due time.Time, next *time.Time) (bool, error) {
+	db := s.driver.Model(&Schedule{}).
+		Where("id = ? AND NOT paused AND next_run_at = ?", scheduleID, due).
+		Update("next_run_at", next)
+	return db.RowsAffected == 1, db.Error
+}
+
+func (s *Storage) DeleteSchedule(scheduleID uint) error {
+	return s.driver.Delete(&Schedule{}, "id = ?", scheduleID).Error
+}
+
+func (s *Storage) SaveScheduleRun(run *ScheduleRun) error {
+	return s.driver.Save(run).Error
+}
+
+// RetrieveLastScheduledJob returns the job the schedule submitted last, nil when it submitted none
+func (s *Storage) RetrieveLastScheduledJob(scheduleID uint) (*Job, error) {
+	var runs []*ScheduleRun
+	err := s.driver.Where("schedule_id = ? AND job_id IS NOT NULL", scheduleID).
+		Order("fired_at DESC, id DESC").Limit(1).Find(&runs).Error
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return s.RetrieveJob(*runs[0].JobID)
+}
@@ -1,16 +1,20 @@
 package storage
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/jinzhu/gorm"
-	"github.com/ufcg-lsd/arrebol-pb/arrebol/service/errors"
 	"github.com/ufcg-lsd/arrebol-pb/arrebol/worker"
 )
 
 func (s *Storage) DropTablesIfExist() *gorm.DB {
-	return s.driver.DropTableIfExists(&Command{}, &TaskConfig{}, &TaskMetadata{},
-		&Task{}, &Job{}, &ResourceNode{}, &Queue{}, &worker.Worker{})
+	return s.driver.DropTableIfExists(&Command{}, &TaskConfig{}, &TaskMetadata{}, &TaskAttempt{},
+		&Task{}, &ScheduleRun{}, &Schedule{}, &Job{}, &ResourceNode{}, &Queue{}, &worker.Worker{}, &SchemaMigration{})
 }
 
 func (s *Storage) CreateTables() {
@@ -18,18 +22,21 @@ func (s *Storage) CreateTables() {
 		"commands":       &Command{},
 		"task_configs":   &TaskConfig{},
 		"task_metadata":  &TaskMetadata{},
+		"task_attempts":  &TaskAttempt{},
 		"tasks":          &Task{},
 		"jobs":           &Job{},
+		"schedules":      &Schedule{},
+		"schedule_runs":  &ScheduleRun{},
 		"resource_nodes": &ResourceNode{},
 		"queues":         &Queue{},
 		"workers":        &worker.Worker{},
 	}
 
-	for _, v := range tables {
+	for name, v := range tables {
 		err, _ := s.CreateTable(v)
 
 		if err != nil {
-
+			log.Printf("Table %s was not created: %s", name, err.Error())
 		}
 	}
 }
@@ -46,60 +53,62 @@ func (s *Storage) CreateTable(t interface{}) (error, string) {
 	}
 }
 
-func (s *Storage) AutoMigrate() {
-	s.driver.AutoMigrate(&Command{}, &TaskConfig{}, &TaskMetadata{},
-		&Task{}, &Job{}, &ResourceNode{}, &Queue{})
-}
-
-func (s *Storage) ConfigureSchema() {
-	s.Driver().Model(
-		&Command{}).AddForeignKey(
-		"task_id", "tasks(id)", "CASCADE", "CASCADE").Model(
-		&TaskMetadata{}).AddForeignKey(
-		"task_id", "tasks(id)", "CASCADE", "CASCADE").Model(
-		&TaskConfig{}).AddForeignKey(
-		"task_id", "tasks(id)", "CASCADE", "CASCADE").Model(
-		&Task{}).AddForeignKey(
-		"job_id", "jobs(id)", "CASCADE", "CASCADE").Model(
-		&ResourceNode{}).AddForeignKey(
-		"queue_id", "queues(id)", "CASCADE", "CASCADE").Model(
-		&Job{}).AddForeignKey(
-		"queue_id", "queues(id)", "CASCADE", "CASCADE").Model(
-		&worker.Worker{}).AddForeignKey("queue_id", "queues(id)", "CASCADE", "CASCADE")
-}
-
-func (s *Storage) CreateSchema() {
-	s.DropTablesIfExist()
-	s.CreateTables()
-	s.AutoMigrate()
-	s.ConfigureSchema()
-}
-
 // swagger:model Queue
 type Queue struct {
 	gorm.Model
 	Name    string           `json:"Name"`
+	Policy  string           `json:"Policy" gorm:"default:'priority'"`
+	State   QueueState       `json:"State"`
 	Jobs    []*Job           `json:"Jobs" gorm:"ForeignKey:QueueID"`
 	Workers []*worker.Worker `json:"Workers" gorm:"ForeignKey:QueueID"`
 	Nodes   []*ResourceNode  `json:"Nodes" gorm:"ForeignKey:QueueID"`
 }
 
+type QueueState uint8
+
+const (
+	QueueOpen QueueState = iota
+	// QueuePaused queues accept jobs but run none of their tasks
+	QueuePaused
+	// QueueDraining queues reject new jobs, running the ones already accepted
+	QueueDraining
+)
+
+func (qs QueueState) String() string {
+	return [...]string{"Open", "Paused", "Draining"}[qs]
+}
+
 type ResourceState uint8
 
 const (
 	Idle ResourceState = iota
 	Allocated
+	// Draining nodes take no new tasks and are removed once the running ones end
+	Draining
 )
 
 func (rs ResourceState) String() string {
-	return [...]string{"Idle", "Allocated"}[rs]
+	return [...]string{"Idle", "Allocated", "Draining"}[rs]
 }
 
+// ResourceNode is a host the workers of a queue run their tasks on
 type ResourceNode struct {
 	gorm.Model
 	QueueID uint          `json:"QueueID"`
 	State   ResourceState `json:"State"`
 	Address string        `json:"Address"`
+	// Driver runs the tasks on the node, raw or docker
+	Driver string `json:"Driver" gorm:"default:'raw'"`
+	// Workers is how many workers the node runs, sharing its capacity evenly, each one
+	// running as many tasks at once as it has slots
+	Workers int `json:"Workers" gorm:"default:1"`
+	Slots   int `json:"Slots" gorm:"default:1"`
+	// CPU, Memory and Disk are the capacity of the node, in cores and bytes. A capacity left
+	// at zero is not accounted.
+	CPU    float64       `json:"CPU"`
+	Memory int64         `json:"Memory"`
+	Disk   int64         `json:"Disk"`
+	Labels worker.Labels `json:"Labels,omitempty" gorm:"type:jsonb"`
 }
 
 type JobState uint8
@@ -109,18 +118,62 @@ const (
 	JobRunning
 	JobFinished
 	JobFailed
+	JobCancelled
 )
 
 func (js JobState) String() string {
-	return [...]string{"Queued", "Running", "Finished", "Failed"}[js]
+	return [...]string{"Queued", "Running", "Finished", "Failed", "Cancelled"}[js]
 }
 
 type Job struct {
 	gorm.Model
-	QueueID uint     `json:"QueueID"`
-	Label   string   `json:"Label"`
-	State   JobState `json:"State"`
-	Tasks   []*Task  `json:"Tasks" gorm:"ForeignKey:JobID"`
+	QueueID           uint     `json:"QueueID"`
+	Label             string   `json:"Label"`
+	State             JobState `json:"State"`
+	Priority          int      `json:"Priority"`
+	OnUpstreamFailure string   `json:"OnUpstreamFailure" gorm:"default:'skip'"`
+	Tasks             []*Task  `json:"Tasks" gorm:"ForeignKey:JobID"`
+}
+
+// Schedule submits a job to its queue each time its cron expression fires
+type Schedule struct {
+	gorm.Model
+	QueueID uint   `json:"QueueID"`
+	Name    string `json:"Name"`
+	Cron    string `json:"Cron"`
+	// Timezone is the location the cron expression is read in, e.g. "America/Recife"
+	Timezone string `json:"Timezone"`
+	// Overlap tells what happens when the schedule fires while its last job has not completed
+	Overlap string `json:"Overlap" gorm:"default:'skip'"`
+	Paused  bool   `json:"Paused"`
+	// Spec is the job spec, as sent to create a job, of the jobs the schedule submits
+	Spec string `json:"Spec"`
+	// NextRunAt is when the schedule fires next, nil while it is paused
+	NextRunAt *time.Time     `json:"NextRunAt"`
+	Runs      []*ScheduleRun `json:"Runs" gorm:"ForeignKey:ScheduleID"`
+}
+
+type RunOutcome uint8
+
+const (
+	RunSubmitted RunOutcome = iota
+	RunSkipped
+	RunFailed
+)
+
+func (ro RunOutcome) String() string {
+	return [...]string{"Submitted", "Skipped", "Failed"}[ro]
+}
+
+// ScheduleRun records a firing of a schedule and the job it submitted, if any
+type ScheduleRun struct {
+	gorm.Model
+	ScheduleID uint       `json:"ScheduleID"`
+	FiredAt    time.Time  `json:"FiredAt"`
+	Outcome    RunOutcome `json:"Outcome"`
+	JobID      *uint      `json:"JobID"`
+	// Reason tells why no job was submitted
+	Reason string `json:"Reason,omitempty"`
 }
 
 type TaskState uint8
@@ -130,10 +183,15 @@ const (
 	TaskRunning
 	TaskFinished
 	TaskFailed
+	TaskCancelled
+	// TaskSkipped is a task that never ran because a task it depends on did not finish
+	TaskSkipped
+	// TaskWaiting is a task whose dependencies have not finished yet, no worker takes it
+	TaskWaiting
 )
 
 func (ts TaskState) String() string {
-	return [...]string{"Pending", "Running", "Finished", "Failed"}[ts]
+	return [...]string{"Pending", "Running", "Finished", "Failed", "Cancelled", "Skipped", "Waiting"}[ts]
 }
 
 type Task struct {
@@ -143,6 +201,86 @@ type Task struct {
 	Config   []TaskConfig   `json:"Config" gorm:"ForeignKey:TaskID"`
 	Metadata []TaskMetadata `json:"Metadata" gorm:"ForeignKey:TaskID"`
 	Commands []*Command     `json:"Commands" gorm:"ForeignKey:TaskID"`
+	// WorkerID is the worker currently running the task, empty while it is pending
+	WorkerID string `json:"WorkerID"`
+	// LeaseExpiresAt is only set when the task was leased by a remote worker
+	LeaseExpiresAt *time.Time `json:"LeaseExpiresAt"`
+	// Reason tells why the task is held in its state, e.g. why no worker can run it
+	Reason string `json:"Reason,omitempty"`
+	// RetryAt is set while a failed task waits to be retried, no worker takes it before
+	RetryAt  *time.Time     `json:"RetryAt,omitempty"`
+	Attempts []*TaskAttempt `json:"Attempts" gorm:"ForeignKey:TaskID"`
+	// SpecID is the ID the task was given in its job spec, naming it to the tasks depending on it
+	SpecID    string       `json:"SpecID,omitempty"`
+	DependsOn Dependencies `json:"DependsOn,omitempty" gorm:"type:jsonb"`
+	// ArrayIndex is the index of the task among the ones expanded from the same array
+	ArrayIndex *int `json:"ArrayIndex,omitempty"`
+}
+
+// Dependencies are the spec IDs of the tasks of the same job that must finish before a task runs
+type Dependencies []string
+
+// Value stores the dependencies as a json array
+func (d Dependencies) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(d)
+}
+
+func (d *Dependencies) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return errors.New("the dependencies of a task must be a json array")
+	}
+}
+
+// TaskAttempt records one execution of a task by a worker
+type TaskAttempt struct {
+	gorm.Model
+	TaskID    uint       `json:"TaskID"`
+	Number    int        `json:"Number"`
+	WorkerID  string     `json:"WorkerID"`
+	StartedAt time.Time  `json:"StartedAt"`
+	EndedAt   *time.Time `json:"EndedAt"`
+	// State is the state the task ended the attempt in, Running while it goes on
+	State     TaskState `json:"State"`
+	ExitCodes ExitCodes `json:"ExitCodes" gorm:"type:jsonb"`
+	// Infrastructure tells the attempt failed because of the worker rather than of the commands
+	Infrastructure bool   `json:"Infrastructure"`
+	Error          string `json:"Error,omitempty"`
+}
+
+// ExitCodes are the exit codes of the commands of a task, in order
+type ExitCodes []int
+
+// Value stores the exit codes as a json array
+func (e ExitCodes) Value() (driver.Value, error) {
+	if e == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(e)
+}
+
+func (e *ExitCodes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*e = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	default:
+		return errors.New("the exit codes of an attempt must be a json array")
+	}
 }
 
 type TaskConfig struct {
@@ -166,16 +304,19 @@ const (
 	CmdRunning
 	CmdFinished
 	CmdFailed
+	CmdCancelled
+	CmdTimedOut
 )
 
 func (cs CommandState) String() string {
-	return [...]string{"NotStarted", "Running", "Finished", "Failed"}[cs]
+	return [...]string{"NotStarted", "Running", "Finished", "Failed", "Cancelled", "TimedOut"}[cs]
 }
 
 type Command struct {
 	gorm.Model
-	TaskID     uint         `json:"TaskID"`
-	ExitCode   int8         `json:"ExitCode"`
+	TaskID uint `json:"TaskID"`
+	// ExitCode is the status as reported by the shell, 128 plus the signal number for killed commands
+	ExitCode   int          `json:"ExitCode"`
 	RawCommand string       `json:"RawCommand"`
 	State      CommandState `json:"State"`
 }
@@ -37,8 +37,12 @@ func New(host string, port string, user string, dbname string, password string)
 	return DB
 }
 
+// Setup applies the pending migrations on the database. It refuses to go on
+// when the database was migrated by a newer version of arrebol.
 func (s *Storage) Setup() {
-	s.CreateSchema()
+	if err := s.Migrate(); err != nil {
+		log.Fatalln(err.Error())
+	}
 	createDefaults(s)
 }
 
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	NoPendingTaskErr  = errors.New("there is no pending task in the queue")
+	LeaseNotHeldErr   = errors.New("the task lease is not held by the worker")
+	TaskNotRunningErr = errors.New("the task is not running")
+)
+
+// leasePriority is the priority declared by a task, or else the one of its job
+const leasePriority = `COALESCE((SELECT task_configs.value::integer FROM task_configs
+	WHERE task_configs.task_id = tasks.id AND task_configs.key = 'priority' AND task_configs.deleted_at IS NULL
+	LIMIT 1), jobs.priority)`
+
+// LeaseCandidates bounds how many of the waiting tasks are considered on each lease
+const LeaseCandidates = 64
+
+// LeaseTask hands to the worker the task of the queue with the highest priority, the oldest
+// among the tied ones, that is pending or whose lease has already expired and that fits
+// the worker. The worker holds the task until the lease expires or is renewed.
+func (s *Storage) LeaseTask(queueID uint, workerID string, duration time.Duration, fits func(*Task) bool) (*Task, error) {
+	var tasks []*Task
+	now := time.Now()
+
+	tx := s.driver.Begin()
+	err := tx.Set("gorm:query_option", "FOR UPDATE OF tasks SKIP LOCKED").
+		Select("tasks.*").
+		Joins("JOIN jobs ON jobs.id = tasks.job_id AND jobs.deleted_at IS NULL").
+		Joins("JOIN queues ON queues.id = jobs.queue_id AND queues.deleted_at IS NULL AND queues.state <> ?", QueuePaused).
+		Where("jobs.queue_id = ?", queueID).
+		Where("tasks.state = ? OR (tasks.state = ? AND tasks.lease_expires_at < ?)", TaskPending, TaskRunning, now).
+		Where("tasks.retry_at IS NULL OR tasks.retry_at <= ?", now).
+		Order(leasePriority + " DESC, tasks.id").
+		Limit(LeaseCandidates).
+		Find(&tasks).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var task *Task
+	for _, candidate := range tasks {
+		if err = tx.Where("task_id = ?", candidate.ID).Find(&candidate.Config).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if fits(candidate) {
+			task = candidate
+			break
+		}
+	}
+	if task == nil {
+		tx.Rollback()
+		return nil, NoPendingTaskErr
+	}
+
+	expiresAt := now.Add(duration)
+	err = tx.Model(task).Updates(map[string]interface{}{
+		"state":            TaskRunning,
+		"worker_id":        workerID,
+		"lease_expires_at": expiresAt,
+		"reason":           "",
+		"retry_at":         nil,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	s.fillTask(task)
+	return task, nil
+}
+
+// RetrieveLeasedTasks returns the tasks the worker holds a lease on
+func (s *Storage) RetrieveLeasedTasks(workerID string) ([]*Task, error) {
+	var tasks []*Task
+	err := s.driver.Where("worker_id = ? AND state = ? AND lease_expires_at > ?", workerID, TaskRunning, time.Now()).
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	s.fillTasks(tasks)
+	return tasks, nil
+}
+
+// RenewLease extends the lease that the worker holds on the task.
+func (s *Storage) RenewLease(taskID uint, workerID string, duration time.Duration) error {
+	db := s.driver.Model(&Task{}).
+		Where("id = ? AND worker_id = ? AND state = ?", taskID, workerID, TaskRunning).
+		Update("lease_expires_at", time.Now().Add(duration))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return LeaseNotHeldErr
+	}
+	return nil
+}
+
+// ReleaseTask ends the lease that the worker holds on the task, moving the task to the given state.
+// Releasing a task as pending gives it back to the queue.
+func (s *Storage) ReleaseTask(taskID uint, workerID string, state TaskState) error {
+	fields := map[string]interface{}{
+		"state":            state,
+		"lease_expires_at": nil,
+	}
+	if state == TaskPending {
+		fields["worker_id"] = ""
+	}
+	db := s.driver.Model(&Task{}).
+		Where("id = ? AND worker_id = ? AND state = ?", taskID, workerID, TaskRunning).
+		Updates(fields)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return LeaseNotHeldErr
+	}
+	return nil
+}
+
+// ClaimTask marks a pending task as running on the worker. It returns false when the task
+// is no longer pending, e.g. because a remote worker has leased it in the meantime.
+func (s *Storage) ClaimTask(taskID uint, workerID string) (bool, error) {
+	db := s.driver.Model(&Task{}).
+		Where("id = ? AND state = ?", taskID, TaskPending).
+		Updates(map[string]interface{}{"state": TaskRunning, "worker_id": workerID, "reason": "", "retry_at": nil})
+	return db.RowsAffected == 1, db.Error
+}
+
+// UnblockTask moves a task whose dependencies have finished from waiting to pending. It
+// returns false when the task is no longer waiting, e.g. because its job was cancelled.
+func (s *Storage) UnblockTask(taskID uint) (bool, error) {
+	db := s.driver.Model(&Task{}).
+		Where("id = ? AND state = ?", taskID, TaskWaiting).
+		Updates(map[string]interface{}{"state": TaskPending, "reason": ""})
+	return db.RowsAffected == 1, db.Error
+}
+
+// SkipTask marks a task that has not started as skipped, recording why. It returns false
+// when the task has started in the meantime.
+func (s *Storage) SkipTask(taskID uint, reason string) (bool, error) {
+	db := s.driver.Model(&Task{}).
+		Where("id = ? AND state IN (?)", taskID, []TaskState{TaskWaiting, TaskPending}).
+		Updates(map[string]interface{}{"state": TaskSkipped, "reason": reason, "retry_at": nil})
+	return db.RowsAffected == 1, db.Error
+}
+
+// SetTaskReason records why the task is held in its state
+func (s *Storage) SetTaskReason(taskID uint, reason string) error {
+	return s.driver.Model(&Task{}).Where("id = ?", taskID).Update("reason", reason).Error
+}
+
+func (s *Storage) RetrieveTaskByQueue(taskID, queueID uint) (*Task, error) {
+	var task Task
+	err := s.driver.Select("tasks.*").
+		Joins("JOIN jobs ON jobs.id = tasks.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.queue_id = ?", queueID).
+		First(&task, taskID).Error
+	if err != nil {
+		return nil, err
+	}
+	s.fillTask(&task)
+	return &task, nil
+}
+
+// RenewLeases extends every lease held by the worker.
+func (s *Storage) RenewLeases(workerID string, duration time.Duration) error {
+	return s.driver.Model(&Task{}).
+		Where("worker_id = ? AND state = ? AND lease_expires_at IS NOT NULL", workerID, TaskRunning).
+		Update("lease_expires_at", time.Now().Add(duration)).Error
+}
+
+// RequeueWorkerTasks gives back to the queue every task that the worker was running.
+func (s *Storage) RequeueWorkerTasks(workerID string) ([]*Task, error) {
+	return s.requeueTasks("worker_id = ? AND state = ?", workerID, TaskRunning)
+}
+
+// RequeueExpiredTasks gives back to the queue every leased task whose lease has expired.
+func (s *Storage) RequeueExpiredTasks() ([]*Task, error) {
+	return s.requeueTasks("state = ? AND lease_expires_at < ?", TaskRunning, time.Now())
+}
+
+// requeueTasks moves the tasks matching the condition back to pending, resetting their
+// commands so that they run again from the start.
+func (s *Storage) requeueTasks(query string, args ...interface{}) ([]*Task, error) {
+	var tasks []*Task
+
+	tx := s.driver.Begin()
+	err := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").Where(query, args...).Find(&tasks).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, task := range tasks {
+		err = tx.Model(task).Updates(map[string]interface{}{
+			"state":            TaskPending,
+			"worker_id":        "",
+			"lease_expires_at": nil,
+		}).Error
+		if err == nil {
+			err = tx.Model(&Command{}).Where("task_id = ?", task.ID).
+				Updates(map[string]interface{}{"state": CmdNotStarted, "exit_code": -1}).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	s.fillTasks(tasks)
+	return tasks, nil
+}
+
+// RequeueTask gives a running task back to the queue, resetting its commands.
+func (s *Storage) RequeueTask(taskID uint) (*Task, error) {
+	tasks, err := s.requeueTasks("id = ? AND state = ?", taskID, TaskRunning)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, TaskNotRunningErr
+	}
+	return tasks[0], nil
+}
+
+// FailTask marks a running task as failed, along with the commands that were running.
+func (s *Storage) FailTask(taskID uint) error {
+	tx := s.driver.Begin()
+	err := tx.Model(&Task{}).Where("id = ?", taskID).
+		Updates(map[string]interface{}{"state": TaskFailed, "lease_expires_at": nil}).Error
+	if err == nil {
+		err = tx.Model(&Command{}).Where("task_id = ? AND state = ?", taskID, CmdRunning).
+			Update("state", CmdFailed).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
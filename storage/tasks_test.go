@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// pendingTask saves a job with a single pending task, on a queue of its own
+func pendingTask(t *testing.T, s *Storage) (uint, *Task) {
+	queue := &Queue{}
+	if err := s.SaveQueue(queue); err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{State: TaskPending}
+	if err := s.SaveJob(&Job{QueueID: queue.ID, Tasks: []*Task{task}}); err != nil {
+		t.Fatal(err)
+	}
+	return queue.ID, task
+}
+
+func anyTask(*Task) bool {
+	return true
+}
+
+func TestExpiredLeaseIsTakenOver(t *testing.T) {
+	s := OpenDriver()
+	s.Setup()
+	defer CloseDriver(s, t)
+	queueID, task := pendingTask(t, s)
+
+	// a lease ending in the past has expired as soon as it is taken
+	if _, err := s.LeaseTask(queueID, "worker-a", -time.Second, anyTask); err != nil {
+		t.Fatal(err)
+	}
+	leased, err := s.LeaseTask(queueID, "worker-b", time.Minute, anyTask)
+	if err != nil || leased.ID != task.ID || leased.WorkerID != "worker-b" {
+		t.Fatalf("got %+v, %v; want task [%d] leased by worker-b", leased, err, task.ID)
+	}
+	if err = s.RenewLease(task.ID, "worker-a", time.Minute); err != LeaseNotHeldErr {
+		t.Errorf("the former holder renewed the lease: %v", err)
+	}
+}
+
+func TestRenewLeaseNotHeld(t *testing.T) {
+	s := OpenDriver()
+	s.Setup()
+	defer CloseDriver(s, t)
+	queueID, task := pendingTask(t, s)
+
+	if _, err := s.LeaseTask(queueID, "worker-a", time.Minute, anyTask); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenewLease(task.ID, "worker-b", time.Hour); err != LeaseNotHeldErr {
+		t.Errorf("a worker renewed the lease of another one: %v", err)
+	}
+	if _, err := s.LeaseTask(queueID, "worker-b", time.Minute, anyTask); err != NoPendingTaskErr {
+		t.Errorf("a task under lease was leased again: %v", err)
+	}
+	if err := s.RenewLease(task.ID, "worker-a", time.Hour); err != nil {
+		t.Errorf("the holder could not renew its lease: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/google/logger"
 	uuid "github.com/satori/go.uuid"
 	"github.com/ufcg-lsd/arrebol-pb/arrebol/worker"
+	"time"
 )
 
 var (
@@ -38,3 +39,26 @@ func (s *Storage) SaveWorker(w worker.Worker) (uuid.UUID, error) {
 		return savedWorker.ID, nil
 	}
 }
+
+func (s *Storage) RetrieveWorker(workerID string) (*worker.Worker, error) {
+	var w worker.Worker
+	err := s.driver.Where("id = ?", workerID).First(&w).Error
+	return &w, err
+}
+
+// TouchWorker records that the worker has just been seen, bringing it back to life if it was lost.
+func (s *Storage) TouchWorker(workerID string) error {
+	return s.driver.Model(&worker.Worker{}).Where("id = ?", workerID).
+		Updates(map[string]interface{}{"state": worker.Alive, "last_seen": time.Now()}).Error
+}
+
+func (s *Storage) SetWorkerState(workerID string, state worker.State) error {
+	return s.driver.Model(&worker.Worker{}).Where("id = ?", workerID).Update("state", state).Error
+}
+
+// RetrieveSilentWorkers returns the alive workers that have not been seen since the given instant.
+func (s *Storage) RetrieveSilentWorkers(since time.Time) ([]*worker.Worker, error) {
+	var workers []*worker.Worker
+	err := s.driver.Where("state = ? AND last_seen < ?", worker.Alive, since).Find(&workers).Error
+	return workers, err
+}
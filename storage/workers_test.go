@@ -47,3 +47,42 @@ func TestHasUUID(t *testing.T) {
 		t.Errorf("error %T", err)
 	}
 }
+
+func TestSilentWorkerTasksAreRequeued(t *testing.T) {
+	s := OpenDriver()
+	s.Setup()
+	defer CloseDriver(s, t)
+	queueID, task := pendingTask(t, s)
+
+	id := uuid.NewV4()
+	w := worker.Worker{Base: worker.Base{ID: id}, QueueID: queueID, State: worker.Alive, LastSeen: time.Now().Add(-time.Hour)}
+	if _, err := s.SaveWorker(w); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LeaseTask(queueID, id.String(), time.Hour, anyTask); err != nil {
+		t.Fatal(err)
+	}
+
+	silent, err := s.RetrieveSilentWorkers(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, sw := range silent {
+		found = found || sw.ID == id
+	}
+	if !found {
+		t.Fatalf("the worker last seen an hour ago is not silent")
+	}
+
+	requeued, err := s.RequeueWorkerTasks(id.String())
+	if err != nil || len(requeued) != 1 || requeued[0].ID != task.ID {
+		t.Fatalf("requeued %v, %v; want task [%d]", requeued, err, task.ID)
+	}
+	if requeued[0].State != TaskPending || requeued[0].WorkerID != "" || requeued[0].LeaseExpiresAt != nil {
+		t.Errorf("requeued task = %+v, want it pending without a lease", requeued[0])
+	}
+	if _, err = s.LeaseTask(queueID, "worker-b", time.Minute, anyTask); err != nil {
+		t.Errorf("the requeued task could not be leased again: %v", err)
+	}
+}